@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Event is something that happened to a task, see GET /activity.
+type Event struct {
+	// Type is created, completed, deleted or commented.
+	Type   string `json:"type"`
+	TaskID int64  `json:"task_id"`
+	// CommentID is the comment of a commented event.
+	CommentID int64     `json:"comment_id,omitempty"`
+	At        time.Time `json:"at"`
+	// Text is the text of the task, or the body of the comment.
+	Text string `json:"text"`
+}
+
+// ActivityPage is the response of GET /activity.
+type ActivityPage struct {
+	Events []Event `json:"events"`
+	// Next is the cursor of the following page, empty on the last one.
+	Next string `json:"next,omitempty"`
+}
+
+// activityCursor is the position after the last event of a page.
+type activityCursor struct {
+	At        time.Time `json:"at"`
+	Type      string    `json:"type"`
+	TaskID    int64     `json:"task_id"`
+	CommentID int64     `json:"comment_id"`
+}
+
+func (c *activityCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseActivityCursor(s string) (*activityCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, newError("invalid_param", "cursor")
+	}
+	var c activityCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, newError("invalid_param", "cursor")
+	}
+	return &c, nil
+}
+
+// activitySQL merges the events recorded by the timestamps of the tasks and
+// the comments. There is no audit log: completions are only known from the
+// latest completed_at, and deleted tasks limited to those not purged yet.
+const activitySQL = `
+	SELECT * FROM (
+		SELECT 'created' AS type, id AS task_id, 0::bigint AS comment_id, created_at AS at, text FROM "Task"
+		UNION ALL
+		SELECT 'completed', id, 0, completed_at, text FROM "Task" WHERE completed_at IS NOT NULL
+		UNION ALL
+		SELECT 'deleted', id, 0, deleted_at, text FROM "Task" WHERE deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'commented', task_id, id, created_at, body FROM "Comment"
+	) AS e
+	WHERE ?
+	ORDER BY at DESC, type DESC, task_id DESC, comment_id DESC
+	LIMIT ?`
+
+// listActivity returns the limit latest events after the cursor, the
+// latest ones when it is nil.
+func listActivity(ctx context.Context, db bun.IDB, cursor *activityCursor, limit int) (ActivityPage, error) {
+	var cond any = bun.Safe("true")
+	if cursor != nil {
+		cond = bun.SafeQuery("(at, type, task_id, comment_id) < (?, ?, ?, ?)",
+			cursor.At, cursor.Type, cursor.TaskID, cursor.CommentID)
+	}
+	activity := ActivityPage{Events: []Event{}}
+	// one more to know whether there is a next page.
+	err := db.NewRaw(activitySQL, cond, limit+1).Scan(ctx, &activity.Events)
+	if err != nil {
+		return activity, err
+	}
+	if len(activity.Events) > limit {
+		activity.Events = activity.Events[:limit]
+		last := activity.Events[limit-1]
+		next := activityCursor{At: last.At, Type: last.Type, TaskID: last.TaskID, CommentID: last.CommentID}
+		activity.Next = next.String()
+	}
+	return activity, nil
+}
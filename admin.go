@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminAuth returns a middleware accepting only requests carrying the token
+// as "Authorization: Bearer <token>".
+func adminAuth(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !validToken(bearerToken(c), []string{token}) {
+				return unauthorized(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// Activity is a backend of the database from pg_stat_activity.
+type Activity struct {
+	PID             int64      `bun:"pid" json:"pid"`
+	ApplicationName string     `bun:"application_name" json:"application_name"`
+	State           string     `bun:"state" json:"state"`
+	WaitEvent       string     `bun:"wait_event" json:"wait_event,omitempty"`
+	QueryStart      *time.Time `bun:"query_start" json:"query_start,omitempty"`
+	Duration        float64    `bun:"duration" json:"duration"`
+	Query           string     `bun:"query" json:"query"`
+}
+
+// DBStats is the connection pool of the database from sql.DBStats.
+// Durations are in seconds.
+type DBStats struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDuration       float64 `json:"wait_duration"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+func newDBStats(s sql.DBStats) DBStats {
+	return DBStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration.Seconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// AgendaHour holds the tasks due within an hour of the day.
+type AgendaHour struct {
+	// Hour is the hour of the day, 0 to 23.
+	Hour  int    `json:"hour"`
+	Tasks []Task `json:"tasks"`
+}
+
+// Agenda is the response of GET /tasks/today.
+type Agenda struct {
+	// Date is the day, YYYY-MM-DD.
+	Date string `json:"date"`
+	// AllDay are the tasks due at midnight, such as those given a day by
+	// POST /tasks/quickadd, which have no time of their own.
+	AllDay []Task `json:"all_day"`
+	// Hours are the hours with tasks due, in order.
+	Hours []AgendaHour `json:"hours"`
+}
+
+// newAgenda buckets tasks due on the day starting at day by the hour of their
+// due date in the location of day. Within an hour the tasks are sorted by
+// due time, and otherwise keep their order.
+func newAgenda(day time.Time, tasks []Task) Agenda {
+	agenda := Agenda{Date: day.Format(time.DateOnly), AllDay: []Task{}, Hours: []AgendaHour{}}
+	slices.SortStableFunc(tasks, func(a, b Task) int { return a.DueDate.Compare(*b.DueDate) })
+	for _, task := range tasks {
+		due := task.DueDate.In(day.Location())
+		if due.Equal(day) {
+			agenda.AllDay = append(agenda.AllDay, task)
+			continue
+		}
+		if n := len(agenda.Hours); n == 0 || agenda.Hours[n-1].Hour != due.Hour() {
+			agenda.Hours = append(agenda.Hours, AgendaHour{Hour: due.Hour()})
+		}
+		h := &agenda.Hours[len(agenda.Hours)-1]
+		h.Tasks = append(h.Tasks, task)
+	}
+	return agenda
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// publicRoutes are the route patterns served without an API key: the
+// readiness probe, metrics, the frontend and the shared tasks, whose links
+// are their own credential. The /admin routes have their own token and
+// are not listed.
+var publicRoutes = []string{
+	"/readyz",
+	"/metrics",
+	"/favicon.ico",
+	"/shared/:token",
+	"/*",
+}
+
+// isPublicRoute reports whether the route pattern is exempt from API key
+// authentication.
+func isPublicRoute(route string, public []string) bool {
+	return slices.Contains(public, route) || route == "/admin" || strings.HasPrefix(route, "/admin/")
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(c echo.Context) string {
+	token, _ := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return token
+}
+
+// validToken reports whether token is one of tokens.
+func validToken(token string, tokens []string) bool {
+	ok := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return token != "" && ok
+}
+
+func unauthorized(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	return c.JSON(http.StatusUnauthorized, msg(c, "unauthorized"))
+}
+
+// requestKey returns the API key of the request, sent as "X-API-Key" or as
+// a bearer token.
+func requestKey(c echo.Context) string {
+	if key := c.Request().Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return bearerToken(c)
+}
+
+// apiKeyAuth returns a middleware requiring one of keys, sent as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", on the routes that
+// are not public.
+func apiKeyAuth(keys, public []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isPublicRoute(c.Path(), public) {
+				return next(c)
+			}
+			if !validToken(requestKey(c), keys) {
+				return unauthorized(c)
+			}
+			return next(c)
+		}
+	}
+}
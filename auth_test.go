@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublicRoutes(t *testing.T) {
+	favicon := filepath.Join(t.TempDir(), "favicon.ico")
+	if err := os.WriteFile(favicon, []byte("icon"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := newTestServer(t, map[string]string{"API_KEYS": "secret", "FAVICON": favicon})
+	registered := map[string]bool{}
+	for _, r := range e.Routes() {
+		if r.Method == http.MethodGet {
+			registered[r.Path] = true
+		}
+	}
+	for _, route := range publicRoutes {
+		if !registered[route] {
+			t.Errorf("public route %s is not registered", route)
+			continue
+		}
+		path := strings.ReplaceAll(route, ":token", "token")
+		path = strings.ReplaceAll(path, "*", "")
+		if rec := serve(e, httptest.NewRequest(http.MethodGet, path, nil)); rec.Code == http.StatusUnauthorized {
+			t.Errorf("GET %s = %d, want no authentication", path, rec.Code)
+		}
+	}
+	if rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /tasks without a key = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
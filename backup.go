@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// backupFormat is the version of the Backup document.
+const backupFormat = 1
+
+// Backup is a full snapshot of the database written by GET /admin/backup
+// and read by POST /admin/restore. Ids are kept so that the dependencies and
+// comments still refer to the same tasks after a restore.
+type Backup struct {
+	Format       int              `json:"format"`
+	CreatedAt    time.Time        `json:"created_at"`
+	Tasks        []BackupTask     `json:"tasks"`
+	Dependencies []TaskDependency `json:"dependencies"`
+	Comments     []Comment        `json:"comments"`
+	Templates    []Template       `json:"templates"`
+}
+
+// BackupTask is a task of a backup. Unlike the API, it includes the tasks
+// that are deleted but not purged yet.
+type BackupTask struct {
+	Task
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// MarshalJSON adds the deleted_at of the task to its JSON, which would be
+// left out by the Task.MarshalJSON promoted from the embedded Task.
+func (t BackupTask) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(t.Task)
+	if err != nil || t.DeletedAt == nil {
+		return b, err
+	}
+	deletedAt, err := json.Marshal(t.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b[:len(b)-1], `,"deleted_at":`...)
+	return append(append(b, deletedAt...), '}'), nil
+}
+
+// dumpBackup reads all the tables into a Backup in one transaction, so the
+// snapshot is consistent.
+func dumpBackup(ctx context.Context, db bun.IDB) (*Backup, error) {
+	backup := &Backup{Format: backupFormat, CreatedAt: time.Now()}
+	err := db.RunInTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(ctx context.Context, tx bun.Tx) error {
+		var tasks []Task
+		err := tx.NewSelect().Model(&tasks).WhereAllWithDeleted().Order("id").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		backup.Tasks = make([]BackupTask, len(tasks))
+		for i, task := range tasks {
+			backup.Tasks[i].Task = task
+			if !task.DeletedAt.IsZero() {
+				backup.Tasks[i].DeletedAt = &task.DeletedAt
+			}
+		}
+		backup.Dependencies = []TaskDependency{}
+		err = tx.NewSelect().Model(&backup.Dependencies).Order("task_id", "blocker_id").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		backup.Comments = []Comment{}
+		err = tx.NewSelect().Model(&backup.Comments).Order("id").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		backup.Templates = []Template{}
+		return tx.NewSelect().Model(&backup.Templates).Order("id").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// validateBackup checks that backup can be restored: the format is known,
+// the ids are unique and the dependencies and comments refer to tasks of
+// the backup.
+func validateBackup(backup *Backup) error {
+	if backup.Format != backupFormat {
+		return newError("invalid_param", "format")
+	}
+	ids := make(map[int64]bool, len(backup.Tasks))
+	for _, task := range backup.Tasks {
+		if task.ID <= 0 || ids[task.ID] {
+			return newError("invalid_param", "tasks")
+		}
+		ids[task.ID] = true
+	}
+	for _, d := range backup.Dependencies {
+		if !ids[d.TaskID] || !ids[d.BlockerID] || d.TaskID == d.BlockerID {
+			return newError("invalid_param", "dependencies")
+		}
+	}
+	comments := make(map[int64]bool, len(backup.Comments))
+	for _, cm := range backup.Comments {
+		if cm.ID <= 0 || comments[cm.ID] || !ids[cm.TaskID] {
+			return newError("invalid_param", "comments")
+		}
+		comments[cm.ID] = true
+	}
+	templates := make(map[int64]bool, len(backup.Templates))
+	for _, tpl := range backup.Templates {
+		if tpl.ID <= 0 || templates[tpl.ID] {
+			return newError("invalid_param", "templates")
+		}
+		templates[tpl.ID] = true
+	}
+	return nil
+}
+
+// errRestoreNotEmpty is returned when restoring into a database that
+// already has tasks or templates.
+var errRestoreNotEmpty = errors.New("database is not empty")
+
+// insertBatchSize is the most rows insertBatches inserts per statement.
+var insertBatchSize = 1000
+
+// insertBatches inserts rows in statements of at most insertBatchSize rows,
+// as one statement with every row of a large backup would be tens of
+// megabytes for the server to parse at once.
+func insertBatches[T any](ctx context.Context, tx bun.Tx, rows []T) error {
+	for chunk := range slices.Chunk(rows, insertBatchSize) {
+		if _, err := tx.NewInsert().Model(&chunk).Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBackup loads backup into the database in one transaction. The
+// database must not have any task, deleted or not, or template: a restore
+// never merges with existing rows, so there are no id conflicts to
+// resolve. The id sequences are moved past the restored ids.
+func restoreBackup(ctx context.Context, db bun.IDB, backup *Backup) error {
+	return withTx(ctx, db, func(ctx context.Context, tx bun.Tx) error {
+		// keeps concurrent creates out until the restore is committed.
+		_, err := tx.ExecContext(ctx, `LOCK TABLE "Task", "TaskDependency", "Comment", "Template" IN EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+		for _, model := range []any{(*Task)(nil), (*Template)(nil)} {
+			q := tx.NewSelect().Model(model)
+			if _, ok := model.(*Task); ok {
+				q = q.WhereAllWithDeleted()
+			}
+			exists, err := q.Exists(ctx)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return errRestoreNotEmpty
+			}
+		}
+		if len(backup.Tasks) > 0 {
+			tasks := make([]Task, len(backup.Tasks))
+			for i, task := range backup.Tasks {
+				tasks[i] = task.Task
+				if task.DeletedAt != nil {
+					tasks[i].DeletedAt = *task.DeletedAt
+				}
+			}
+			if err := insertBatches(ctx, tx, tasks); err != nil {
+				return err
+			}
+		}
+		if len(backup.Dependencies) > 0 {
+			if err := insertBatches(ctx, tx, backup.Dependencies); err != nil {
+				return err
+			}
+		}
+		if len(backup.Comments) > 0 {
+			if err := insertBatches(ctx, tx, backup.Comments); err != nil {
+				return err
+			}
+		}
+		if len(backup.Templates) > 0 {
+			if err := insertBatches(ctx, tx, backup.Templates); err != nil {
+				return err
+			}
+		}
+		for _, table := range []string{"Task", "Comment", "Template"} {
+			_, err := tx.NewRaw(
+				"SELECT setval(pg_get_serial_sequence(?, 'id'), coalesce(max(id), 0) + 1, false) FROM ?",
+				`"`+table+`"`, bun.Ident(table),
+			).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
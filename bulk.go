@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BulkResult is the outcome of one item of a bulk request in partial mode,
+// or of the item that failed a bulk request otherwise.
+type BulkResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Task   *Task  `json:"task,omitempty"`
+}
+
+// bulkItemError is the error of the item at index that aborted a bulk
+// request.
+type bulkItemError struct {
+	index int
+	err   error
+}
+
+func (e *bulkItemError) Error() string { return e.err.Error() }
+
+func (e *bulkItemError) Unwrap() error { return e.err }
+
+// bulkStatus returns the status code and the localized message of an item
+// error, like storeError does for single task requests.
+func bulkStatus(c echo.Context, err error, maxTasks int64) (int, string) {
+	var he *echo.HTTPError
+	var le *priorityLimitError
+	var de *duplicateTaskError
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		return http.StatusNotFound, msg(c, "task_not_found")
+	case errors.Is(err, errTaskBlocked):
+		return http.StatusConflict, msg(c, "task_blocked")
+	case errors.Is(err, errTaskLimit):
+		return http.StatusForbidden, msg(c, "task_limit", maxTasks)
+	case errors.As(err, &le):
+		return http.StatusConflict, msg(c, "priority_limit", le.limit, le.priority)
+	case errors.As(err, &de):
+		return http.StatusConflict, msg(c, "task_duplicate", de.task.ID)
+	case errors.As(err, &he):
+		return he.Code, errorMessage(c, he.Message)
+	case dbUnavailable(err):
+		c.Logger().Error(err)
+		return http.StatusServiceUnavailable, msg(c, "database_unavailable")
+	}
+	c.Logger().Error(err)
+	return http.StatusInternalServerError, errorMessage(c, err)
+}
+
+// runBulk applies fn to the n items of a bulk request. By default the items
+// are applied in one transaction and the first failure rolls back all of
+// them. With ?partial=true every item is applied on its own and the
+// response is 207 Multi-Status with a BulkResult per item.
+func runBulk(c echo.Context, store TaskStore, maxTasks int64, n int, fn func(ctx context.Context, store TaskStore, i int) (*Task, error)) error {
+	if n == 0 || n > maxIDs {
+		return c.String(http.StatusBadRequest, msg(c, "bulk_size", maxIDs))
+	}
+	partial, _ := strconv.ParseBool(c.QueryParam("partial"))
+	if partial {
+		results := make([]BulkResult, n)
+		for i := range results {
+			results[i].Index = i
+			task, err := fn(c.Request().Context(), store, i)
+			if err != nil {
+				results[i].Status, results[i].Error = bulkStatus(c, err, maxTasks)
+				continue
+			}
+			results[i].Status, results[i].Task = http.StatusOK, task
+		}
+		return c.JSON(http.StatusMultiStatus, results)
+	}
+
+	tasks := make([]Task, n)
+	err := store.Atomic(c.Request().Context(), func(ctx context.Context, store TaskStore) error {
+		for i := range tasks {
+			task, err := fn(ctx, store, i)
+			if err != nil {
+				return &bulkItemError{index: i, err: err}
+			}
+			tasks[i] = *task
+		}
+		return nil
+	})
+	var ie *bulkItemError
+	if errors.As(err, &ie) {
+		status, message := bulkStatus(c, ie.err, maxTasks)
+		return c.JSON(status, BulkResult{Index: ie.index, Status: status, Error: message})
+	}
+	if err != nil {
+		return storeError(c, err)
+	}
+	return c.JSON(http.StatusOK, tasks)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cacheRule is how long the responses of a route are fresh, and for how
+// long after that they may still be served while being refreshed.
+type cacheRule struct {
+	ttl time.Duration
+	swr time.Duration
+}
+
+// parseCacheRules parses RESPONSE_CACHE, e.g. "/tasks=5s:30s,
+// /tasks/heatmap=1m:10m", routes with their TTL and optional
+// stale-while-revalidate window.
+func parseCacheRules(s string) (map[string]cacheRule, error) {
+	rules := map[string]cacheRule{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		route, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule %q", kv)
+		}
+		ttl, swr, _ := strings.Cut(v, ":")
+		var rule cacheRule
+		var err error
+		if rule.ttl, err = time.ParseDuration(ttl); err != nil || rule.ttl <= 0 {
+			return nil, fmt.Errorf("invalid ttl %q", ttl)
+		}
+		if swr != "" {
+			if rule.swr, err = time.ParseDuration(swr); err != nil || rule.swr < 0 {
+				return nil, fmt.Errorf("invalid stale-while-revalidate %q", swr)
+			}
+		}
+		rules[route] = rule
+	}
+	return rules, nil
+}
+
+// cachedResponse is a response kept by a responseCache.
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// responseCache keeps the 200 responses of the GET routes with a rule in
+// memory. Fresh responses are served from the cache, and responses within
+// the stale-while-revalidate window are too while the handler runs again
+// in the background to refresh them. Any other request, which may change
+// the tasks, empties the cache; refreshes in flight are then dropped.
+type responseCache struct {
+	rules map[string]cacheRule
+
+	mu         sync.Mutex
+	entries    map[string]*cachedResponse
+	refreshing map[string]bool
+	// gen counts the times the cache was emptied.
+	gen uint64
+}
+
+func newResponseCache(rules map[string]cacheRule) *responseCache {
+	return &responseCache{rules: rules, entries: map[string]*cachedResponse{}, refreshing: map[string]bool{}}
+}
+
+// key returns the key of the request, the headers changing the response
+// included.
+func (rc *responseCache) key(req *http.Request) string {
+	key := req.URL.RequestURI()
+	for _, h := range []string{echo.HeaderAccept, "Accept-Language", "X-Envelope", "Prefer"} {
+		key += "\x00" + req.Header.Get(h)
+	}
+	return key
+}
+
+// cacheRecorder passes a response on while keeping a copy of its body.
+type cacheRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// discardWriter is the response writer of the background refreshes.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// run runs next on c and records its response under key unless the cache
+// was emptied since gen.
+func (rc *responseCache) run(next echo.HandlerFunc, c echo.Context, key string, gen uint64) error {
+	res := c.Response()
+	rec := &cacheRecorder{ResponseWriter: res.Writer}
+	res.Writer = rec
+	err := next(c)
+	res.Writer = rec.ResponseWriter
+	if err != nil || res.Status != http.StatusOK {
+		return err
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.gen == gen {
+		rc.entries[key] = &cachedResponse{header: res.Header().Clone(), body: rec.body.Bytes(), at: time.Now()}
+	}
+	return nil
+}
+
+// refresh runs the handler of c again in the background for key.
+func (rc *responseCache) refresh(next echo.HandlerFunc, c echo.Context, key string, gen uint64) {
+	req := c.Request().Clone(context.Background())
+	bg := c.Echo().NewContext(req, &discardWriter{header: http.Header{}})
+	bg.SetPath(c.Path())
+	bg.SetParamNames(c.ParamNames()...)
+	bg.SetParamValues(c.ParamValues()...)
+	go func() {
+		defer func() {
+			rc.mu.Lock()
+			delete(rc.refreshing, key)
+			rc.mu.Unlock()
+		}()
+		if err := rc.run(next, bg, key, gen); err != nil {
+			bg.Logger().Error("cache refresh: ", err)
+		}
+	}()
+}
+
+func (rc *responseCache) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method != http.MethodGet && req.Method != http.MethodHead && req.Method != http.MethodOptions {
+			err := next(c)
+			rc.mu.Lock()
+			clear(rc.entries)
+			rc.gen++
+			rc.mu.Unlock()
+			return err
+		}
+		rule, ok := rc.rules[c.Path()]
+		if !ok || req.Method != http.MethodGet || c.QueryParam("format") == "ndjson" {
+			return next(c)
+		}
+		c.Response().Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, stale-while-revalidate=%d",
+			int(rule.ttl.Seconds()), int(rule.swr.Seconds())))
+
+		key := rc.key(req)
+		rc.mu.Lock()
+		entry, gen := rc.entries[key], rc.gen
+		age := time.Duration(0)
+		if entry != nil {
+			age = time.Since(entry.at)
+		}
+		stale := entry != nil && age >= rule.ttl
+		if entry != nil && age >= rule.ttl+rule.swr {
+			entry = nil
+		}
+		if entry != nil && stale && !rc.refreshing[key] {
+			rc.refreshing[key] = true
+			rc.refresh(next, c, key, gen)
+		}
+		rc.mu.Unlock()
+
+		if entry == nil {
+			return rc.run(next, c, key, gen)
+		}
+		h := c.Response().Header()
+		for k, v := range entry.header {
+			h[k] = v
+		}
+		h.Set("Age", strconv.Itoa(int(age.Seconds())))
+		return c.Blob(http.StatusOK, entry.header.Get(echo.HeaderContentType), entry.body)
+	}
+}
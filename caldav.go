@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// caldavUIDPrefix starts the UID of the VTODO items of the tasks. Items
+// with other UIDs belong to other clients and are left alone.
+const caldavUIDPrefix = name + "-"
+
+// caldavSync mirrors the tasks with a due date to a CalDAV calendar
+// collection as VTODO items, every interval:
+//
+//   - tasks created or changed since the last sync are PUT, and tasks that
+//     were deleted or lost their due date are DELETEd;
+//   - the completion of the items is read back with a calendar-query
+//     REPORT and applied to the tasks that differ.
+//
+// Local changes are pushed before the completion is read, so a task
+// changed on both sides within one interval keeps the local state.
+// Deletions are only known for UNDO_WINDOW, which should be longer than
+// the interval. Other properties edited in the calendar are overwritten
+// on the next local change.
+type caldavSync struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	store    TaskStore
+}
+
+// run syncs every interval until ctx is done.
+func (s *caldavSync) run(ctx context.Context, interval time.Duration) {
+	var since time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now, err := s.sync(ctx, since)
+		if err != nil {
+			log.Print("caldav: ", err)
+		} else {
+			since = now
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync pushes the changes since since and pulls the completion of the
+// items. It returns the time to pass as since to the next sync.
+func (s *caldavSync) sync(ctx context.Context, since time.Time) (time.Time, error) {
+	now := time.Now()
+	tasks, deleted, err := s.store.Changes(ctx, since)
+	if err != nil {
+		return since, err
+	}
+	for _, task := range tasks {
+		if task.DueDate != nil {
+			err = s.do(ctx, http.MethodPut, s.itemURL(task.ID), "text/calendar; charset=utf-8", vtodo(&task))
+		} else if !since.IsZero() {
+			err = s.do(ctx, http.MethodDelete, s.itemURL(task.ID), "", nil)
+		}
+		if err != nil {
+			return since, err
+		}
+	}
+	for _, id := range deleted {
+		if err := s.do(ctx, http.MethodDelete, s.itemURL(id), "", nil); err != nil {
+			return since, err
+		}
+	}
+
+	completed, err := s.completed(ctx)
+	if err != nil || len(completed) == 0 {
+		return now, err
+	}
+	ids := make([]int64, 0, len(completed))
+	for id := range completed {
+		ids = append(ids, id)
+	}
+	local, err := s.store.List(ctx, TaskFilter{IDs: ids})
+	if err != nil {
+		return now, err
+	}
+	for _, task := range local {
+		done := completed[task.ID]
+		if task.Completed == done {
+			continue
+		}
+		_, err := s.store.Update(ctx, task.ID, func(t *Task) error {
+			t.Completed = done
+			return nil
+		})
+		if err != nil {
+			// e.g. blocked; the calendar is corrected by the next push.
+			log.Printf("caldav: task %d: %v", task.ID, err)
+		}
+	}
+	return now, nil
+}
+
+func (s *caldavSync) itemURL(id int64) string {
+	return strings.TrimSuffix(s.url, "/") + "/" + caldavUIDPrefix + strconv.FormatInt(id, 10) + ".ics"
+}
+
+// do sends a request to the server. Deleting a missing item succeeds.
+func (s *caldavSync) do(ctx context.Context, method, url, contentType string, body []byte) error {
+	_, err := s.request(ctx, method, url, contentType, nil, body)
+	return err
+}
+
+func (s *caldavSync) request(ctx context.Context, method, url, contentType string, header http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 && !(method == http.MethodDelete && res.StatusCode == http.StatusNotFound) {
+		return nil, fmt.Errorf("%s %s: %s", method, url, res.Status)
+	}
+	return b, nil
+}
+
+const caldavQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO"/></C:comp-filter></C:filter>
+</C:calendar-query>`
+
+// completed returns whether the VTODO item of each task on the server is
+// completed, by task id.
+func (s *caldavSync) completed(ctx context.Context) (map[int64]bool, error) {
+	b, err := s.request(ctx, "REPORT", s.url, "application/xml; charset=utf-8", http.Header{"Depth": {"1"}}, []byte(caldavQuery))
+	if err != nil {
+		return nil, err
+	}
+	var ms struct {
+		Responses []struct {
+			Data []string `xml:"propstat>prop>calendar-data"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal(b, &ms); err != nil {
+		return nil, err
+	}
+	completed := map[int64]bool{}
+	for _, r := range ms.Responses {
+		for _, data := range r.Data {
+			props := vtodoProps(data)
+			s, ok := strings.CutPrefix(props["UID"], caldavUIDPrefix)
+			if !ok {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				continue
+			}
+			completed[id] = props["STATUS"] == "COMPLETED"
+		}
+	}
+	return completed, nil
+}
+
+// vtodoProps returns the properties of the first VTODO of an iCalendar
+// object by name, without parameters.
+func vtodoProps(data string) map[string]string {
+	props := map[string]string{}
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if n := len(lines); n > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[n-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	in := false
+	for _, line := range lines {
+		switch line {
+		case "BEGIN:VTODO":
+			in = true
+			continue
+		case "END:VTODO":
+			return props
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !in || !ok {
+			continue
+		}
+		k, _, _ = strings.Cut(k, ";")
+		props[strings.ToUpper(k)] = v
+	}
+	return props
+}
+
+// icalPriorities maps task priorities to iCalendar ones, 1 highest and 9
+// lowest.
+var icalPriorities = [maxPriority + 1]int{0, 9, 5, 1}
+
+// vtodo returns the iCalendar object of the task.
+func vtodo(t *Task) []byte {
+	const stamp = "20060102T150405Z"
+	var b bytes.Buffer
+	line := func(s string) {
+		// lines are folded at 75 octets, the leading space of the
+		// continuations included, without splitting a character.
+		for n := 75; len(s) > n; n = 74 {
+			i := n
+			for i > 0 && s[i]&0xc0 == 0x80 {
+				i--
+			}
+			b.WriteString(s[:i] + "\r\n ")
+			s = s[i:]
+		}
+		b.WriteString(s + "\r\n")
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//" + name + "//" + version + "//EN")
+	line("BEGIN:VTODO")
+	line("UID:" + caldavUIDPrefix + strconv.FormatInt(t.ID, 10))
+	line("DTSTAMP:" + t.UpdatedAt.UTC().Format(stamp))
+	line("SUMMARY:" + icalText(t.Text))
+	line("DUE:" + t.DueDate.UTC().Format(stamp))
+	if p := icalPriorities[t.Priority]; p > 0 {
+		line("PRIORITY:" + strconv.Itoa(p))
+	}
+	if t.Completed {
+		line("STATUS:COMPLETED")
+		if t.CompletedAt != nil {
+			line("COMPLETED:" + t.CompletedAt.UTC().Format(stamp))
+		}
+	} else {
+		line("STATUS:NEEDS-ACTION")
+	}
+	line("END:VTODO")
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// icalText escapes s as an iCalendar TEXT value.
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxChecklistItems and maxChecklistItemLength are the most items of a
+// checklist and the most characters of their text.
+const (
+	maxChecklistItems      = 100
+	maxChecklistItemLength = 200
+)
+
+// ChecklistItem is a step within a task.
+type ChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// ChecklistProgress is how many items of the checklist of a task are done.
+type ChecklistProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// checklistProgress returns the progress of the checklist, or nil when it
+// is empty.
+func checklistProgress(items []ChecklistItem) *ChecklistProgress {
+	if len(items) == 0 {
+		return nil
+	}
+	p := &ChecklistProgress{Total: len(items)}
+	for _, item := range items {
+		if item.Done {
+			p.Done++
+		}
+	}
+	return p
+}
+
+// MarshalJSON adds the checklist_progress of the task.
+func (t Task) MarshalJSON() ([]byte, error) {
+	type task Task
+	return json.Marshal(struct {
+		task
+		ChecklistProgress *ChecklistProgress `json:"checklist_progress,omitempty"`
+	}{task(t), checklistProgress(t.Checklist)})
+}
+
+// sanitizeChecklist trims the text of the items.
+func sanitizeChecklist(items []ChecklistItem) {
+	for i := range items {
+		items[i].Text = strings.TrimSpace(items[i].Text)
+	}
+}
+
+// validateChecklist checks the items of a checklist.
+func validateChecklist(items []ChecklistItem) error {
+	if len(items) > maxChecklistItems {
+		return newError("too_many_items", maxChecklistItems)
+	}
+	for _, item := range items {
+		if item.Text == "" {
+			return newError("missing_param", "text")
+		}
+		if utf8.RuneCountInString(item.Text) > maxChecklistItemLength {
+			return newError("too_long", "text", maxChecklistItemLength)
+		}
+	}
+	return nil
+}
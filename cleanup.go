@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// cleanupCompleted deletes the tasks completed more than retention ago
+// every interval until ctx is done. They are deleted like with DELETE
+// /tasks/:id, so they can be brought back with POST /tasks/undo within
+// undoWindow, after which they are purged for good.
+func cleanupCompleted(ctx context.Context, store TaskStore, retention, interval, undoWindow time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		n, err := store.DeleteCompleted(ctx, now.Add(-retention))
+		if err == nil {
+			err = store.Purge(ctx, now.Add(-undoWindow))
+		}
+		if err != nil {
+			log.Print("cleanup: ", err)
+		} else if n > 0 {
+			log.Printf("cleanup: deleted %d tasks completed before %s", n, now.Add(-retention).Format(time.RFC3339))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescingStore is a TaskStore sharing one call of List and Count between
+// concurrent callers asking for the same filter, so that bursts of
+// identical list requests make a single database round trip. A caller
+// joining a call in flight may not see a write it made meanwhile. The
+// shared call is not canceled with the caller that started it and lasts
+// at most timeout, while each caller stops waiting when its own context
+// is done.
+type coalescingStore struct {
+	TaskStore
+	group   singleflight.Group
+	timeout time.Duration
+}
+
+func newCoalescingStore(store TaskStore, timeout time.Duration) *coalescingStore {
+	return &coalescingStore{TaskStore: store, timeout: timeout}
+}
+
+// key returns the key of the calls for f. TaskFilter only holds JSON
+// values, so the encoding covers every field.
+func (s *coalescingStore) key(op string, f TaskFilter) string {
+	b, _ := json.Marshal(f)
+	return op + string(b)
+}
+
+// do returns the result of the call of fn shared by the callers of key.
+func (s *coalescingStore) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	ch := s.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+		defer cancel()
+		return fn(ctx)
+	})
+	select {
+	case r := <-ch:
+		return r.Val, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *coalescingStore) List(ctx context.Context, f TaskFilter) ([]Task, error) {
+	v, err := s.do(ctx, s.key("list", f), func(ctx context.Context) (any, error) {
+		return s.TaskStore.List(ctx, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// callers may reorder the tasks.
+	return slices.Clone(v.([]Task)), nil
+}
+
+func (s *coalescingStore) Count(ctx context.Context, f TaskFilter) (int, error) {
+	v, err := s.do(ctx, s.key("count", f), func(ctx context.Context) (any, error) {
+		return s.TaskStore.Count(ctx, f)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
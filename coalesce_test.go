@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore is a TaskStore whose List takes a database round trip and
+// counts its calls.
+type slowStore struct {
+	TaskStore
+	calls atomic.Int64
+}
+
+func (s *slowStore) List(ctx context.Context, f TaskFilter) ([]Task, error) {
+	s.calls.Add(1)
+	time.Sleep(time.Millisecond)
+	return s.TaskStore.List(ctx, f)
+}
+
+// blockingStore is a TaskStore whose List signals started, which must
+// have room for every call, and waits for release.
+type blockingStore struct {
+	TaskStore
+	started, release chan struct{}
+}
+
+func (s *blockingStore) List(ctx context.Context, f TaskFilter) ([]Task, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.TaskStore.List(ctx, f)
+}
+
+// TestCoalescedCancel checks that canceling the caller that started a
+// shared call only fails that caller.
+func TestCoalescedCancel(t *testing.T) {
+	blocking := &blockingStore{TaskStore: newMemoryStore(0, nil, false), started: make(chan struct{}, 2), release: make(chan struct{})}
+	store := newCoalescingStore(blocking, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := store.List(ctx, TaskFilter{})
+		first <- err
+	}()
+	<-blocking.started
+	second := make(chan error)
+	go func() {
+		_, err := store.List(context.Background(), TaskFilter{})
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: err = %v, want %v", err, context.Canceled)
+	}
+	close(blocking.release)
+	if err := <-second; err != nil {
+		t.Errorf("other caller: %v", err)
+	}
+}
+
+// BenchmarkCoalescingStore reports the List calls reaching the store per
+// request for concurrent identical list requests, with and without
+// COALESCE_LISTS.
+func BenchmarkCoalescingStore(b *testing.B) {
+	for _, bb := range []struct {
+		name     string
+		coalesce bool
+	}{
+		{"direct", false},
+		{"coalesced", true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			slow := &slowStore{TaskStore: newMemoryStore(0, nil, false)}
+			var store TaskStore = slow
+			if bb.coalesce {
+				store = newCoalescingStore(slow, time.Minute)
+			}
+			ctx := context.Background()
+			for range 100 {
+				if err := store.Create(ctx, &Task{Text: "benchmark"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := store.List(ctx, TaskFilter{}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(slow.calls.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
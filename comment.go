@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/uptrace/bun"
+)
+
+// maxCommentLength and maxAuthorLength are the most characters of the body
+// and the author of a comment.
+const (
+	maxCommentLength = 2000
+	maxAuthorLength  = 100
+)
+
+// Comment is a note left on a task.
+type Comment struct {
+	bun.BaseModel `bun:"table:Comment,alias:cm"`
+
+	ID        int64     `bun:"id,pk,autoincrement" json:"id"`
+	TaskID    int64     `bun:"task_id,notnull" json:"task_id"`
+	Author    string    `bun:"author,notnull,default:''" json:"author"`
+	Body      string    `bun:"body,notnull" json:"body"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+func migrateComments(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*Comment)(nil)).
+		IfNotExists().
+		ForeignKey(`("task_id") REFERENCES "Task" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewCreateIndex().Model((*Comment)(nil)).
+		Index("comment_task_id_idx").
+		Column("task_id", "created_at").
+		IfNotExists().
+		Exec(ctx)
+	return err
+}
+
+// sanitizeComment returns s as valid UTF-8 without control characters other
+// than newlines and tabs, and without leading and trailing spaces. Markup is
+// kept as is; clients escape the body like the task text.
+func sanitizeComment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, ""))
+	return strings.TrimSpace(s)
+}
+
+// validateComment sanitizes and checks the fields of a comment about to be
+// saved.
+func validateComment(c *Comment) error {
+	c.Author = sanitizeComment(c.Author)
+	c.Body = sanitizeComment(c.Body)
+	if c.Body == "" {
+		return newError("missing_param", "body")
+	}
+	if n := utf8.RuneCountInString(c.Body); n > maxCommentLength {
+		return newError("too_long", "body", maxCommentLength)
+	}
+	if n := utf8.RuneCountInString(c.Author); n > maxAuthorLength {
+		return newError("too_long", "author", maxAuthorLength)
+	}
+	return nil
+}
+
+// loadCommentCounts fills CommentCount of tasks.
+func loadCommentCounts(ctx context.Context, db bun.IDB, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(tasks))
+	index := make(map[int64]*Task, len(tasks))
+	for i := range tasks {
+		ids[i] = tasks[i].ID
+		index[tasks[i].ID] = &tasks[i]
+	}
+	for ids := range slices.Chunk(ids, idChunkSize) {
+		var counts []struct {
+			TaskID int64
+			Count  int
+		}
+		err := db.NewSelect().Model((*Comment)(nil)).
+			Column("task_id").
+			ColumnExpr("count(*) AS count").
+			Where("task_id IN (?)", bun.In(ids)).
+			Group("task_id").
+			Scan(ctx, &counts)
+		if err != nil {
+			return err
+		}
+		for _, c := range counts {
+			index[c.TaskID].CommentCount = c.Count
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// originPattern is an allowed origin of CORS_ALLOWED_ORIGINS. The origin
+// "https://app.example.com" only allows itself, and "https://*.example.com"
+// any subdomain of example.com, at any depth, but not example.com itself.
+// The scheme and the port always have to be the same.
+type originPattern struct {
+	scheme string
+	// host is the host, or with wildcard the domain whose subdomains are
+	// allowed.
+	host     string
+	port     string
+	wildcard bool
+}
+
+// parseOrigin parses an origin, "scheme://host[:port]" with nothing else.
+func parseOrigin(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid origin %q", s)
+	}
+	return u, nil
+}
+
+// parseOriginPatterns parses CORS_ALLOWED_ORIGINS, a comma separated list of
+// originPatterns.
+func parseOriginPatterns(s string) ([]originPattern, error) {
+	var patterns []originPattern
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		origin, wildcard := o, false
+		if scheme, rest, ok := strings.Cut(o, "://*."); ok {
+			origin, wildcard = scheme+"://"+rest, true
+		}
+		if strings.Contains(origin, "*") {
+			return nil, fmt.Errorf("invalid origin %q: * is only allowed as the first label", o)
+		}
+		u, err := parseOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		host := strings.ToLower(u.Hostname())
+		if wildcard && !strings.Contains(host, ".") {
+			return nil, fmt.Errorf("invalid origin %q: the wildcard needs a domain with at least two labels", o)
+		}
+		patterns = append(patterns, originPattern{scheme: strings.ToLower(u.Scheme), host: host, port: u.Port(), wildcard: wildcard})
+	}
+	return patterns, nil
+}
+
+func (p *originPattern) match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if strings.ToLower(u.Scheme) != p.scheme || u.Port() != p.port {
+		return false
+	}
+	if !p.wildcard {
+		return host == p.host
+	}
+	sub, ok := strings.CutSuffix(host, "."+p.host)
+	return ok && sub != "" && !strings.HasSuffix(sub, ".")
+}
+
+// allowOrigin reports whether the Origin header of a request matches one of
+// patterns. The origin is parsed rather than compared as a string, so that
+// "https://example.com.evil.com" or "https://evilexample.com" never pass
+// for a subdomain of example.com.
+func allowOrigin(patterns []originPattern, origin string) bool {
+	u, err := parseOrigin(origin)
+	if err != nil {
+		return false
+	}
+	for _, p := range patterns {
+		if p.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// cors returns the CORS middleware allowing the origins matching patterns.
+func cors(patterns []originPattern) echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			return allowOrigin(patterns, origin), nil
+		},
+		ExposeHeaders: []string{"ETag", "Location", "Preference-Applied", "Warning"},
+	})
+}
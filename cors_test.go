@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestAllowOrigin(t *testing.T) {
+	patterns, err := parseOriginPatterns("https://app.example.com, https://*.example.org, http://localhost:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.Example.com", true},
+		{"https://app.example.com/", true},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"http://localhost:3000", true},
+
+		// Suffix and prefix matches.
+		{"https://evilapp.example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://evilexample.org", false},
+		{"https://example.org", false},
+		{"https://example.org.evil.com", false},
+		{"https://.example.org", false},
+		{"https://a..example.org", false},
+
+		// Scheme and port mismatches.
+		{"http://app.example.com", false},
+		{"http://api.example.org", false},
+		{"https://localhost:3000", false},
+		{"https://app.example.com:8443", false},
+		{"http://localhost", false},
+		{"http://localhost:30000", false},
+
+		// Opaque and malformed origins.
+		{"null", false},
+		{"", false},
+		{"app.example.com", false},
+		{"https://user@app.example.com", false},
+		{"https://app.example.com/path", false},
+		{"https://app.example.com?x=1", false},
+		{"https://app.example.com#x", false},
+	}
+	for _, tt := range tests {
+		if got := allowOrigin(patterns, tt.origin); got != tt.want {
+			t.Errorf("allowOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseOriginPatterns(t *testing.T) {
+	for _, s := range []string{
+		"https://*",
+		"https://*.com",
+		"https://a.*.example.com",
+		"https://*example.com",
+		"example.com",
+		"https://example.com/path",
+	} {
+		if _, err := parseOriginPatterns(s); err == nil {
+			t.Errorf("parseOriginPatterns(%q) succeeded, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+)
+
+// startOfDay returns midnight of the day t falls on, in t's location.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// snoozeUntil returns the new due date of a task snoozed at now. A duration
+// postpones the current due date, or now when the task is not due yet or
+// already overdue. The presets "tomorrow" and "next_week" move the task to
+// the start of the next day or the next Monday in the location of now.
+func snoozeUntil(due *time.Time, now time.Time, duration, preset string) (time.Time, error) {
+	switch preset {
+	case "":
+	case "tomorrow":
+		return startOfDay(now).AddDate(0, 0, 1), nil
+	case "next_week":
+		days := (8 - int(now.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return startOfDay(now).AddDate(0, 0, days), nil
+	default:
+		return time.Time{}, newError("unknown_preset", preset)
+	}
+	if duration == "" {
+		return time.Time{}, newError("duration_required")
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if d <= 0 {
+		return time.Time{}, newError("duration_positive")
+	}
+	base := now
+	if due != nil && due.After(now) {
+		base = *due
+	}
+	return base.Add(d), nil
+}
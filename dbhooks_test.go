@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// BenchmarkQueryHooks measures the overhead the query hooks of each
+// APP_ENV add to a query, over a fake driver doing no work.
+func BenchmarkQueryHooks(b *testing.B) {
+	for _, bb := range []struct {
+		name        string
+		development bool
+	}{
+		{"production", false},
+		{"development", true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			db, conn := newRecordingDB()
+			defer db.Close()
+			for _, hook := range queryHooks(bb.development, 3*time.Second, nil, io.Discard) {
+				db.AddQueryHook(hook)
+			}
+			ctx := context.Background()
+			task := &Task{ID: 1, Text: "benchmark"}
+			b.ReportAllocs()
+			for b.Loop() {
+				conn.events = conn.events[:0]
+				if _, err := db.NewUpdate().Model(task).Column("text").WherePK().Exec(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
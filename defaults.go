@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// TaskDefaults are the values given to a new task for the fields the client
+// leaves out.
+type TaskDefaults struct {
+	Priority int
+	// DueIn sets the due date relative to the creation time. Zero leaves
+	// the task without a due date.
+	DueIn time.Duration
+}
+
+// newTask returns a task created at now with the defaults filled in. The
+// request body is decoded over it, so explicit values win.
+func (d TaskDefaults) newTask(now time.Time) Task {
+	task := Task{Priority: d.Priority}
+	if d.DueIn > 0 {
+		due := now.Add(d.DueIn)
+		task.DueDate = &due
+	}
+	return task
+}
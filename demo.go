@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// databaseRoutes are the route patterns querying the database directly
+// instead of going through the TaskStore, besides the /admin routes.
+var databaseRoutes = []string{
+	"/tasks/:id/reorder",
+	"/tasks/toggle",
+	"/tasks/:id/blockers",
+	"/tasks/:id/blockers/:blocker",
+	"/tasks/:id/merge",
+	"/tasks/:id/comments",
+	"/tasks/:id/next",
+	"/tasks/:id/prev",
+	"/activity",
+	"/tags",
+	"/templates",
+	"/templates/:id",
+	"/templates/:id/instantiate",
+	"/preferences",
+}
+
+// requireDatabase answers the databaseRoutes and the /admin routes with
+// 501 Not Implemented, for demo mode where the tasks are only kept by the
+// memoryStore.
+func requireDatabase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route := c.Path()
+		if slices.Contains(databaseRoutes, route) || route == "/admin" || strings.HasPrefix(route, "/admin/") {
+			return c.JSON(http.StatusNotImplemented, msg(c, "demo_unsupported"))
+		}
+		return next(c)
+	}
+}
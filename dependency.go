@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/uptrace/bun"
+)
+
+// errTaskBlocked is returned when completing a task that still has
+// incomplete blockers.
+var errTaskBlocked = errors.New("task is blocked")
+
+// TaskDependency records that the task TaskID is blocked by BlockerID.
+type TaskDependency struct {
+	bun.BaseModel `bun:"table:TaskDependency,alias:d"`
+
+	TaskID    int64 `bun:"task_id,pk" json:"task_id"`
+	BlockerID int64 `bun:"blocker_id,pk" json:"blocker_id"`
+}
+
+func migrateDependencies(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*TaskDependency)(nil)).
+		IfNotExists().
+		ForeignKey(`("task_id") REFERENCES "Task" ("id") ON DELETE CASCADE`).
+		ForeignKey(`("blocker_id") REFERENCES "Task" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewCreateIndex().Model((*TaskDependency)(nil)).
+		Index("task_dependency_blocker_idx").
+		Column("blocker_id").
+		IfNotExists().
+		Exec(ctx)
+	return err
+}
+
+// loadDependencies fills BlockedBy and Blocks of tasks.
+func loadDependencies(ctx context.Context, db bun.IDB, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(tasks))
+	index := make(map[int64]*Task, len(tasks))
+	for i := range tasks {
+		ids[i] = tasks[i].ID
+		index[tasks[i].ID] = &tasks[i]
+	}
+	// a dependency between tasks of different chunks is found by both, so
+	// the chunks are merged before filling the tasks.
+	deps := map[TaskDependency]bool{}
+	for ids := range slices.Chunk(ids, idChunkSize) {
+		var chunk []TaskDependency
+		// deleted tasks keep their dependencies until purged but are hidden.
+		err := db.NewSelect().Model(&chunk).
+			Join(`JOIN "Task" AS t ON t.id = d.task_id AND t.deleted_at IS NULL`).
+			Join(`JOIN "Task" AS b ON b.id = d.blocker_id AND b.deleted_at IS NULL`).
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("d.task_id IN (?)", bun.In(ids)).WhereOr("d.blocker_id IN (?)", bun.In(ids))
+			}).
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range chunk {
+			deps[d] = true
+		}
+	}
+	for _, d := range slices.SortedFunc(maps.Keys(deps), func(a, b TaskDependency) int {
+		return cmp.Or(cmp.Compare(a.TaskID, b.TaskID), cmp.Compare(a.BlockerID, b.BlockerID))
+	}) {
+		if t, ok := index[d.TaskID]; ok {
+			t.BlockedBy = append(t.BlockedBy, d.BlockerID)
+		}
+		if t, ok := index[d.BlockerID]; ok {
+			t.Blocks = append(t.Blocks, d.TaskID)
+		}
+	}
+	return nil
+}
+
+// hasIncompleteBlockers reports whether the task is blocked by a task that
+// is not completed yet.
+func hasIncompleteBlockers(ctx context.Context, db bun.IDB, id int64) (bool, error) {
+	return db.NewSelect().Model((*TaskDependency)(nil)).
+		Join(`JOIN "Task" AS b ON b.id = d.blocker_id`).
+		Where("d.task_id = ?", id).
+		Where("NOT b.completed").
+		Where("b.deleted_at IS NULL").
+		Exists(ctx)
+}
+
+// dependsOn reports whether the task id is blocked by other directly or
+// through a chain of blockers.
+func dependsOn(ctx context.Context, db bun.IDB, id, other int64) (bool, error) {
+	var found bool
+	err := db.NewRaw(`
+		WITH RECURSIVE chain(id) AS (
+			SELECT blocker_id FROM "TaskDependency" WHERE task_id = ?
+			UNION
+			SELECT d.blocker_id FROM "TaskDependency" AS d JOIN chain ON d.task_id = chain.id
+		)
+		SELECT EXISTS (SELECT 1 FROM chain WHERE id = ?)`, id, other).Scan(ctx, &found)
+	return found, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// duplicateTaskError is returned when creating a task with the text of an
+// open task while duplicates are rejected, see DUPLICATE_TASKS.
+type duplicateTaskError struct {
+	task *Task
+}
+
+func (e *duplicateTaskError) Error() string {
+	return "duplicate of task " + formatID(e.task.ID)
+}
+
+// foldedText is the SQL of foldText for the text column, which the
+// task_text_fold_idx index is on.
+const foldedText = `lower(btrim(text) COLLATE "C")`
+
+// foldText returns s without its surrounding spaces and with its ASCII
+// letters lowercased, like foldedText whatever the locale of the database.
+// Other letters are kept as they are, since Go and the locales of
+// Postgres do not fold them the same way: a text whose case differs
+// outside ASCII is not a duplicate for either.
+func foldText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, strings.Trim(s, " "))
+}
+
+// sameText reports whether two task texts are the same once folded by
+// foldText.
+func sameText(a, b string) bool {
+	return foldText(a) == foldText(b)
+}
+
+// findDuplicate returns a duplicateTaskError for the open task other than
+// the task id with the same text as text, or nil when there is none.
+func findDuplicate(ctx context.Context, db bun.IDB, text string, id int64) error {
+	var task Task
+	err := db.NewSelect().Model(&task).
+		Where(foldedText+" = ?", foldText(text)).
+		Where("NOT completed").
+		Where("id <> ?", id).
+		Order("id").
+		Limit(1).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &duplicateTaskError{task: &task}
+}
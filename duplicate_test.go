@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestSameText(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Buy milk", "buy MILK", true},
+		{"  buy milk ", "buy milk", true},
+		{"buy  milk", "buy milk", false},
+		// btrim only trims spaces.
+		{"\tbuy milk\n", "buy milk", false},
+		{"\u00a0buy milk", "buy milk", false},
+		// only ASCII letters are folded, in Go as in Postgres.
+		{"École", "école", false},
+		{"\u212a", "k", false},
+		{"straße", "STRASSE", false},
+		{"école", "école", true},
+	}
+	for _, tt := range tests {
+		if got := sameText(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameText(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// Estimate sums the estimates of tasks.
+type Estimate struct {
+	Minutes int `json:"minutes"`
+	Tasks   int `json:"tasks"`
+	// Unestimated is the number of tasks without an estimate, which count
+	// as zero minutes.
+	Unestimated int `json:"unestimated"`
+}
+
+func (e *Estimate) add(t *Task) {
+	e.Tasks++
+	if t.EstimateMinutes == nil {
+		e.Unestimated++
+		return
+	}
+	e.Minutes += *t.EstimateMinutes
+}
+
+// EstimateReport is the response of GET /tasks/estimate. Groups is only
+// set with ?by=.
+type EstimateReport struct {
+	Estimate
+	Groups map[string]*Estimate `json:"groups,omitempty"`
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// taskFileName returns the name of the Markdown file of the task in an
+// export, its id and the first words of its text, e.g. "12-buy-milk.md".
+func taskFileName(t *Task) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(t.Text) {
+		if b.Len() >= 50 {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	if b.Len() == 0 {
+		return formatID(t.ID) + ".md"
+	}
+	return formatID(t.ID) + "-" + b.String() + ".md"
+}
+
+// streamZip writes the tasks matching f as a zip archive of one Markdown
+// file per task, see taskMarkdown. The archive is written to the response
+// as the tasks are read, so like streamNDJSON an error after the first task
+// ends it early, and the client gets a truncated archive.
+func streamZip(c echo.Context, store TaskStore, f TaskFilter) error {
+	res := c.Response()
+	start := func() {
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, "application/zip")
+			res.Header().Set(echo.HeaderContentDisposition,
+				fmt.Sprintf(`attachment; filename="%s-tasks-%s.zip"`, name, time.Now().UTC().Format("20060102T150405Z")))
+			res.WriteHeader(http.StatusOK)
+		}
+	}
+	zw := zip.NewWriter(res)
+	err := store.Each(c.Request().Context(), f, func(task *Task) error {
+		start()
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     taskFileName(task),
+			Method:   zip.Deflate,
+			Modified: task.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, taskMarkdown(task))
+		return err
+	})
+	if err != nil && !res.Committed {
+		return storeError(c, err)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return nil
+	}
+	start()
+	return zw.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+const graphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	tasks(completed: Boolean, ids: [ID!]): [Task!]!
+	task(id: ID!): Task
+}
+
+type Mutation {
+	createTask(text: String!, dueDate: String, color: String, priority: Int): Task!
+	updateTask(id: ID!, text: String, completed: Boolean, color: String, priority: Int): Task
+	toggleTask(id: ID!): Task
+	deleteTask(id: ID!): Boolean!
+}
+
+type Task {
+	id: ID!
+	text: String!
+	completed: Boolean!
+	rank: String!
+	dueDate: String
+	color: String!
+	priority: Int!
+}
+`
+
+// newGraphQLSchema returns the schema served on /graphql. It resolves
+// against the same store and task defaults as the REST handlers.
+func newGraphQLSchema(store TaskStore, defaults TaskDefaults) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &gqlResolver{store: store, defaults: defaults})
+}
+
+type gqlResolver struct {
+	store    TaskStore
+	defaults TaskDefaults
+}
+
+type gqlTask struct {
+	t *Task
+}
+
+func (r gqlTask) ID() graphql.ID  { return graphql.ID(formatID(r.t.ID)) }
+func (r gqlTask) Text() string    { return r.t.Text }
+func (r gqlTask) Completed() bool { return r.t.Completed }
+func (r gqlTask) Rank() string    { return r.t.Rank }
+func (r gqlTask) Color() string   { return r.t.Color }
+func (r gqlTask) Priority() int32 { return int32(r.t.Priority) }
+
+func (r gqlTask) DueDate() *string {
+	if r.t.DueDate == nil {
+		return nil
+	}
+	s := r.t.DueDate.Format(time.RFC3339)
+	return &s
+}
+
+func parseGQLID(id graphql.ID) (int64, error) {
+	return parseID(string(id))
+}
+
+func (r *gqlResolver) Tasks(ctx context.Context, args struct {
+	Completed *bool
+	IDs       *[]graphql.ID
+}) ([]gqlTask, error) {
+	f := TaskFilter{Completed: args.Completed}
+	if args.IDs != nil {
+		if len(*args.IDs) > maxIDs {
+			return nil, newError("too_many_ids", maxIDs)
+		}
+		f.IDs = make([]int64, 0, len(*args.IDs))
+		for _, s := range *args.IDs {
+			id, err := parseGQLID(s)
+			if err != nil {
+				return nil, err
+			}
+			f.IDs = append(f.IDs, id)
+		}
+	}
+	tasks, err := r.store.List(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]gqlTask, len(tasks))
+	for i := range tasks {
+		result[i] = gqlTask{&tasks[i]}
+	}
+	return result, nil
+}
+
+func (r *gqlResolver) Task(ctx context.Context, args struct{ ID graphql.ID }) (*gqlTask, error) {
+	id, err := parseGQLID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := r.store.Get(ctx, id)
+	if errors.Is(err, errTaskNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gqlTask{task}, nil
+}
+
+func (r *gqlResolver) CreateTask(ctx context.Context, args struct {
+	Text     string
+	DueDate  *string
+	Color    *string
+	Priority *int32
+}) (gqlTask, error) {
+	task := r.defaults.newTask(time.Now())
+	task.Text = args.Text
+	if args.Color != nil {
+		task.Color = *args.Color
+	}
+	if args.Priority != nil {
+		task.Priority = int(*args.Priority)
+	}
+	if args.DueDate != nil {
+		due, err := time.Parse(time.RFC3339, *args.DueDate)
+		if err != nil {
+			return gqlTask{}, err
+		}
+		task.DueDate = &due
+	}
+	if err := validateTask(&task); err != nil {
+		return gqlTask{}, err
+	}
+	if err := r.store.Create(ctx, &task); err != nil {
+		return gqlTask{}, err
+	}
+	return gqlTask{&task}, nil
+}
+
+// update applies fn to the task with the id and saves it. A missing task
+// resolves to null.
+func (r *gqlResolver) update(ctx context.Context, id graphql.ID, fn func(*Task)) (*gqlTask, error) {
+	n, err := parseGQLID(id)
+	if err != nil {
+		return nil, err
+	}
+	task, err := r.store.Update(ctx, n, func(task *Task) error {
+		fn(task)
+		return validateTask(task)
+	})
+	if errors.Is(err, errTaskNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gqlTask{task}, nil
+}
+
+func (r *gqlResolver) UpdateTask(ctx context.Context, args struct {
+	ID        graphql.ID
+	Text      *string
+	Completed *bool
+	Color     *string
+	Priority  *int32
+}) (*gqlTask, error) {
+	return r.update(ctx, args.ID, func(task *Task) {
+		if args.Text != nil {
+			task.Text = *args.Text
+		}
+		if args.Completed != nil {
+			task.Completed = *args.Completed
+		}
+		if args.Color != nil {
+			task.Color = *args.Color
+		}
+		if args.Priority != nil {
+			task.Priority = int(*args.Priority)
+		}
+	})
+}
+
+func (r *gqlResolver) ToggleTask(ctx context.Context, args struct{ ID graphql.ID }) (*gqlTask, error) {
+	return r.update(ctx, args.ID, func(task *Task) {
+		task.Completed = !task.Completed
+	})
+}
+
+func (r *gqlResolver) DeleteTask(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	id, err := parseGQLID(args.ID)
+	if err != nil {
+		return false, err
+	}
+	_, err = r.store.Delete(ctx, id, time.Now())
+	if errors.Is(err, errTaskNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
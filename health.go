@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readyCheck is a dependency checked by GET /readyz.
+type readyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// ReadyStatus is the outcome of one readyCheck. Duration is in
+// milliseconds.
+type ReadyStatus struct {
+	OK       bool    `json:"ok"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Readiness is the response of GET /readyz.
+type Readiness struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]ReadyStatus `json:"checks"`
+}
+
+// httpCheck returns a check requesting the URL with GET. It fails on
+// network errors and on 5xx responses.
+func httpCheck(url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 500 {
+			return fmt.Errorf("%s: %s", url, res.Status)
+		}
+		return nil
+	}
+}
+
+// diskCheck returns a check failing when the file system of path has less
+// than min bytes available.
+func diskCheck(path string, min uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		free, err := diskFree(path)
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%s: %d bytes free, want %d", path, free, min)
+		}
+		return nil
+	}
+}
+
+// parseReadyChecks parses READY_CHECK_URLS, "name=url,...", and
+// READY_CHECK_DISKS, "path=bytes,...", into checks.
+func parseReadyChecks(urls, disks string) ([]readyCheck, error) {
+	var checks []readyCheck
+	for _, s := range strings.Split(urls, ",") {
+		if s == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(s, "=")
+		if !ok || name == "" || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("READY_CHECK_URLS: invalid %q", s)
+		}
+		checks = append(checks, readyCheck{name: name, check: httpCheck(url)})
+	}
+	for _, s := range strings.Split(disks, ",") {
+		if s == "" {
+			continue
+		}
+		path, size, ok := strings.Cut(s, "=")
+		min, err := strconv.ParseUint(size, 10, 64)
+		if !ok || path == "" || err != nil {
+			return nil, fmt.Errorf("READY_CHECK_DISKS: invalid %q", s)
+		}
+		checks = append(checks, readyCheck{name: "disk:" + path, check: diskCheck(path, min)})
+	}
+	return checks, nil
+}
+
+// readyHandler runs the checks concurrently, each bounded by timeout so a
+// slow dependency cannot hang the probe, and responds 200 when all pass and
+// 503 Service Unavailable otherwise.
+func readyHandler(checks []readyCheck, timeout time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		readiness := Readiness{OK: true, Checks: make(map[string]ReadyStatus, len(checks))}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, rc := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+				defer cancel()
+				start := time.Now()
+				err := rc.check(ctx)
+				status := ReadyStatus{OK: err == nil, Duration: float64(time.Since(start).Microseconds()) / 1000}
+				if err != nil {
+					status.Error = err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				readiness.Checks[rc.name] = status
+				readiness.OK = readiness.OK && status.OK
+			}()
+		}
+		wg.Wait()
+		if !readiness.OK {
+			return c.JSON(http.StatusServiceUnavailable, readiness)
+		}
+		return c.JSON(http.StatusOK, readiness)
+	}
+}
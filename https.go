@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parsePrefixes parses a comma separated list of CIDR ranges or addresses,
+// e.g. "10.0.0.0/8,127.0.0.1".
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// httpsRedirect returns a middleware redirecting the requests a proxy of
+// trusted received over plain HTTP, as told by X-Forwarded-Proto, to HTTPS.
+// The header is ignored from any other peer, which could forge it, and
+// requests without it are served as they are, so that a proxy left out of
+// trusted cannot cause a redirect loop. The readiness probe is never
+// redirected.
+// GET and HEAD are answered 301 and other methods 308, which clients
+// repeat with the same method and body.
+func httpsRedirect(trusted []netip.Prefix) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.URL.Path == "/readyz" ||
+				!strings.EqualFold(req.Header.Get(echo.HeaderXForwardedProto), "http") {
+				return next(c)
+			}
+			peer, err := netip.ParseAddrPort(req.RemoteAddr)
+			if err != nil || !slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(peer.Addr().Unmap()) }) {
+				return next(c)
+			}
+			code := http.StatusPermanentRedirect
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			return c.Redirect(code, "https://"+req.Host+req.URL.RequestURI())
+		}
+	}
+}
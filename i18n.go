@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// languages are the languages with a message catalog. The first one is the
+// fallback for clients asking for anything else.
+var languages = []language.Tag{language.English, language.Japanese}
+
+var languageMatcher = language.NewMatcher(languages)
+
+// messages are the user facing messages keyed by language and message key.
+// A key missing from a catalog falls back to English.
+var messages = map[language.Tag]map[string]string{
+	language.English: {
+		"task_limit":           "Task limit reached (%d)",
+		"too_many_requests":    "Too many concurrent requests",
+		"quota_exceeded":       "Daily quota of %d writes exceeded",
+		"unauthorized":         "Unauthorized",
+		"admin_read_only":      "Cancelling queries is disabled",
+		"backend_not_found":    "Backend not found",
+		"restore_not_empty":    "Restore needs a database without tasks or templates",
+		"request_timeout":      "Request timed out",
+		"database_unavailable": "The database is unavailable, try again later",
+		"demo_unsupported":     "Not available in demo mode",
+		"stats_timeout":        "The statistics took too long, try a narrower range",
+		"task_not_found":       "Task not found",
+		"warning_past_due":     "due date is in the past",
+		"warning_duplicate":    "text looks like a duplicate of task #%s",
+		"warning_existing":     "an open task with the same text exists, #%d would be returned",
+		"warning_task_limit":   "approaching the task limit (%d of %d)",
+		"warning_tag_limit":    "approaching the tag limit (%d of %d)",
+		"template_not_found":   "Template not found",
+		"no_adjacent_task":     "No adjacent task",
+		"nothing_to_undo":      "Nothing to undo",
+		"task_blocked":         "Task is blocked by incomplete tasks",
+		"external_id_taken":    "external_id is already used by another task",
+		"task_duplicate":       "Task %d has the same text",
+		"priority_limit":       "Limit of %d open tasks of priority %d reached",
+		"dependency_self":      "A task cannot block itself",
+		"merge_self":           "A task cannot be merged into itself",
+		"dependency_cycle":     "Dependency would create a cycle",
+		"dependency_not_found": "Dependency not found",
+		"neighbor_not_found":   "Neighbor not found",
+		"prev_after_next":      "prev must be placed before next",
+		"too_many_ids":         "too many ids (max %d)",
+		"too_many_tags":        "too many tags (%d, max %d)",
+		"metadata_too_deep":    "metadata is nested too deeply (%d levels, max %d)",
+		"metadata_too_large":   "metadata is too large (%d bytes, max %d)",
+		"too_many_items":       "too many checklist items (max %d)",
+		"ids_count":            "ids must have 1 to %d elements",
+		"bulk_size":            "bulk requests must have 1 to %d items",
+		"import_size":          "too many tasks to import (%d, max %d)",
+		"invalid_id":           "invalid id %q",
+		"invalid_param":        "invalid %s",
+		"invalid_date":         "could not understand the date %q",
+		"missing_param":        "%s is required",
+		"too_long":             "%s must be at most %d characters",
+		"unknown_source":       "unknown import source %q",
+		"unknown_fields":       "unknown fields: %s",
+		"invalid_sort":         "invalid sort %q",
+		"invalid_include":      "invalid include %q",
+		"invalid_group":        "cannot group by %q",
+		"invalid_color":        "invalid color %q",
+		"invalid_priority":     "invalid priority %d: must be between 0 and %d",
+		"invalid_estimate":     "invalid estimate %d: must not be negative",
+		"invalid_reminder":     "invalid reminder offset %d: must not be negative",
+		"start_after_due":      "start date must not be after the due date",
+		"unknown_preset":       "unknown preset %q",
+		"duration_required":    "duration or preset is required",
+		"duration_positive":    "duration must be positive",
+	},
+	language.Japanese: {
+		"task_limit":           "タスク数が上限 (%d) に達しました",
+		"too_many_requests":    "同時リクエスト数が多すぎます",
+		"quota_exceeded":       "1日の書き込み上限 %d 回を超えました",
+		"unauthorized":         "認証されていません",
+		"admin_read_only":      "クエリのキャンセルは無効になっています",
+		"backend_not_found":    "バックエンドが見つかりません",
+		"restore_not_empty":    "復元するにはタスクとテンプレートが空のデータベースが必要です",
+		"request_timeout":      "リクエストがタイムアウトしました",
+		"database_unavailable": "データベースに接続できません。しばらくしてから再度お試しください",
+		"demo_unsupported":     "デモモードでは使えません",
+		"stats_timeout":        "集計に時間がかかりすぎました。範囲を狭めてください",
+		"task_not_found":       "タスクが見つかりません",
+		"warning_past_due":     "期日が過去の日時です",
+		"warning_duplicate":    "タスク #%s と重複している可能性があります",
+		"warning_existing":     "同じ内容の未完了のタスク #%d があり、そちらが返されます",
+		"warning_task_limit":   "タスク数が上限に近づいています (%d 件 / %d 件)",
+		"warning_tag_limit":    "タグ数が上限に近づいています (%d 件 / %d 件)",
+		"template_not_found":   "テンプレートが見つかりません",
+		"no_adjacent_task":     "隣のタスクがありません",
+		"nothing_to_undo":      "元に戻す操作がありません",
+		"task_blocked":         "未完了のタスクにブロックされています",
+		"external_id_taken":    "external_id はほかのタスクで使われています",
+		"task_duplicate":       "同じ内容のタスク %d があります",
+		"priority_limit":       "優先度 %[2]d の未完了タスクは %[1]d 件までです",
+		"dependency_self":      "タスク自身をブロッカーにはできません",
+		"merge_self":           "タスクをそれ自身にマージすることはできません",
+		"dependency_cycle":     "依存関係が循環します",
+		"dependency_not_found": "依存関係が見つかりません",
+		"neighbor_not_found":   "隣のタスクが見つかりません",
+		"prev_after_next":      "prev は next より前のタスクを指定してください",
+		"too_many_ids":         "id が多すぎます (最大 %d 件)",
+		"too_many_tags":        "タグが多すぎます (%d 件、最大 %d 件)",
+		"metadata_too_deep":    "メタデータの入れ子が深すぎます (%d 階層、最大 %d 階層)",
+		"metadata_too_large":   "メタデータが大きすぎます (%d バイト、最大 %d バイト)",
+		"too_many_items":       "チェックリストの項目が多すぎます (最大 %d 件)",
+		"ids_count":            "ids には 1 件から %d 件の id を指定してください",
+		"bulk_size":            "一括リクエストには 1 件から %d 件の項目を指定してください",
+		"import_size":          "インポートするタスクが多すぎます (%d 件、最大 %d 件)",
+		"invalid_id":           "不正な id です: %q",
+		"invalid_param":        "%s が不正です",
+		"invalid_date":         "日付を解釈できません: %q",
+		"missing_param":        "%s は必須です",
+		"too_long":             "%s は %d 文字以内にしてください",
+		"unknown_source":       "不明なインポート元です: %q",
+		"unknown_fields":       "不明なフィールドがあります: %s",
+		"invalid_sort":         "不正な sort です: %q",
+		"invalid_include":      "不正な include です: %q",
+		"invalid_group":        "%q ではグループ化できません",
+		"invalid_color":        "不正な色です: %q",
+		"invalid_priority":     "不正な優先度です: %d (0から%dまで)",
+		"invalid_estimate":     "不正な見積もりです: %d (負の値は指定できません)",
+		"invalid_reminder":     "不正なリマインダーの時間です: %d (負の値は指定できません)",
+		"start_after_due":      "開始日を期限より後にすることはできません",
+		"unknown_preset":       "不明なプリセットです: %q",
+		"duration_required":    "duration か preset を指定してください",
+		"duration_positive":    "duration には正の値を指定してください",
+	},
+}
+
+// localError is an error whose message is looked up in the catalogs when
+// it is sent to a client. Error returns the English message.
+type localError struct {
+	key  string
+	args []any
+}
+
+func newError(key string, args ...any) error {
+	return &localError{key: key, args: args}
+}
+
+func (e *localError) Error() string {
+	return translate(language.English, e.key, e.args...)
+}
+
+func translate(tag language.Tag, key string, args ...any) string {
+	format, ok := messages[tag][key]
+	if !ok {
+		format = messages[language.English][key]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// requestLanguage returns the catalog language matching the Accept-Language
+// header of the request best.
+func requestLanguage(c echo.Context) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(c.Request().Header.Get("Accept-Language"))
+	_, i, _ := languageMatcher.Match(tags...)
+	return languages[i]
+}
+
+// msg returns the message for key localized for the request.
+func msg(c echo.Context, key string, args ...any) string {
+	return translate(requestLanguage(c), key, args...)
+}
+
+// errorMessage returns the text of v for the client, localizing errors
+// created by newError.
+func errorMessage(c echo.Context, v any) string {
+	var le *localError
+	if err, ok := v.(error); ok && errors.As(err, &le) {
+		return msg(c, le.key, le.args...)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// idCipher encrypts the ids the API shows under TASK_ID_SCHEME=uuid, and
+// is nil under the default serial scheme, where they are the numbers of
+// the database.
+var idCipher cipher.Block
+
+// newIDCipher returns the cipher of the ids of the scheme, nil for serial.
+// The key of uuid is derived from secret, which must not be empty.
+func newIDCipher(scheme, secret string) (cipher.Block, error) {
+	switch scheme {
+	case "serial":
+		return nil, nil
+	case "uuid":
+		if secret == "" {
+			return nil, errors.New("TASK_ID_KEY is required")
+		}
+		key := sha256.Sum256([]byte(secret))
+		return aes.NewCipher(key[:])
+	}
+	return nil, errors.New("must be serial or uuid")
+}
+
+// formatID returns the id as the API shows it. Under the uuid scheme the
+// id, padded with zeros to an AES block, is encrypted and written like a
+// UUID, e.g. "5f0c6b1e-9a3d-4c27-b8e1-2d7f6a90c413", so that it says
+// nothing of the number of tasks and cannot be guessed from another one.
+func formatID(id int64) string {
+	if idCipher == nil {
+		return strconv.FormatInt(id, 10)
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[8:], uint64(id))
+	idCipher.Encrypt(b[:], b[:])
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// parseID returns the id of s written by formatID. Under the uuid scheme
+// numbers are refused, and so are UUIDs not made with the key, which do
+// not decrypt to the zero padding.
+func parseID(s string) (int64, error) {
+	if idCipher == nil {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, newError("invalid_id", s)
+		}
+		return id, nil
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return 0, newError("invalid_id", s)
+	}
+	idCipher.Decrypt(b, b)
+	if binary.BigEndian.Uint64(b[:8]) != 0 {
+		return 0, newError("invalid_id", s)
+	}
+	return int64(binary.BigEndian.Uint64(b[8:])), nil
+}
+
+// publicIDs is the rewriteJSON function writing the ids of a response
+// with formatID under the uuid scheme.
+func publicIDs(name string, t reflect.Type, tok json.Token) json.Token {
+	if n, ok := tok.(json.Number); ok && idFields[name] && isInt(t) {
+		if id, err := n.Int64(); err == nil {
+			return formatID(id)
+		}
+	}
+	return tok
+}
+
+// internalIDs returns a rewriteJSON function reading the ids of a request
+// body with parseID under the uuid scheme. The first id it cannot read,
+// numbers included, is left in *err.
+func internalIDs(err *error) func(name string, t reflect.Type, tok json.Token) json.Token {
+	return func(name string, t reflect.Type, tok json.Token) json.Token {
+		if !idFields[name] || !isInt(t) {
+			return tok
+		}
+		var s string
+		switch tok := tok.(type) {
+		case string:
+			s = tok
+		case json.Number:
+			s = tok.String()
+		default:
+			return tok
+		}
+		id, e := parseID(s)
+		if e != nil {
+			if *err == nil {
+				*err = e
+			}
+			return tok
+		}
+		return json.Number(strconv.FormatInt(id, 10))
+	}
+}
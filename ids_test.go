@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseID(t *testing.T) {
+	block, err := newIDCipher("uuid", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idCipher = block
+	t.Cleanup(func() { idCipher = nil })
+	seen := map[string]bool{}
+	for _, id := range []int64{1, 2, 42, 1 << 40} {
+		s := formatID(id)
+		if len(s) != 36 || strings.Count(s, "-") != 4 || seen[s] {
+			t.Errorf("formatID(%d) = %q", id, s)
+		}
+		seen[s] = true
+		if got, err := parseID(strings.ToUpper(s)); err != nil || got != id {
+			t.Errorf("parseID(%q) = %d, %v, want %d", s, got, err, id)
+		}
+	}
+	other, _ := newIDCipher("uuid", "other secret")
+	idCipher = other
+	foreign := formatID(1)
+	idCipher = block
+	for _, s := range []string{"1", "", "not-a-uuid", foreign} {
+		if id, err := parseID(s); err == nil {
+			t.Errorf("parseID(%q) = %d, want an error", s, id)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportSkip is an item of an import that did not become a task.
+type ImportSkip struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ImportResult is the response of POST /tasks/import.
+type ImportResult struct {
+	Imported []Task       `json:"imported"`
+	Skipped  []ImportSkip `json:"skipped"`
+}
+
+// importers convert an export of another app, keyed by ?source=, to tasks.
+// Items that cannot be converted are reported as skipped.
+var importers = map[string]func(b []byte) ([]Task, []ImportSkip, error){
+	"todoist": importTodoist,
+	"text":    importText,
+}
+
+// todoistID is the id of a Todoist object, a number in older exports and a
+// string in newer ones.
+type todoistID string
+
+func (id *todoistID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*id = todoistID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = todoistID(n)
+	return nil
+}
+
+// todoistExport is the part of a Todoist sync export used by the importer.
+type todoistExport struct {
+	Projects []struct {
+		ID   todoistID `json:"id"`
+		Name string    `json:"name"`
+	} `json:"projects"`
+	Items []struct {
+		ID        todoistID `json:"id"`
+		Content   string    `json:"content"`
+		ProjectID todoistID `json:"project_id"`
+		// Priority goes from 1 (normal) to 4 (urgent).
+		Priority  int  `json:"priority"`
+		Checked   bool `json:"checked"`
+		IsDeleted bool `json:"is_deleted"`
+		Due       *struct {
+			Date string `json:"date"`
+		} `json:"due"`
+	} `json:"items"`
+}
+
+// parseTodoistDate parses the due date of a Todoist item, which is a date,
+// a floating local time or a UTC time.
+func parseTodoistDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid due date %q", s)
+}
+
+// importTodoist converts the items of a Todoist export. Todoist projects
+// have no counterpart, so the project name is kept in the metadata as
+// "todoist_project".
+func importTodoist(b []byte) ([]Task, []ImportSkip, error) {
+	var export todoistExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, nil, err
+	}
+	projects := map[todoistID]string{}
+	for _, p := range export.Projects {
+		projects[p.ID] = p.Name
+	}
+	tasks := []Task{}
+	skipped := []ImportSkip{}
+	for _, item := range export.Items {
+		switch {
+		case item.IsDeleted:
+			skipped = append(skipped, ImportSkip{ID: string(item.ID), Reason: "deleted"})
+			continue
+		case item.Content == "":
+			skipped = append(skipped, ImportSkip{ID: string(item.ID), Reason: "empty content"})
+			continue
+		}
+		task := Task{
+			Text:      item.Content,
+			Completed: item.Checked,
+			Priority:  min(max(item.Priority-1, 0), maxPriority),
+			Metadata:  map[string]any{"todoist_id": string(item.ID)},
+		}
+		if name, ok := projects[item.ProjectID]; ok {
+			task.Metadata["todoist_project"] = name
+		}
+		if item.Due != nil && item.Due.Date != "" {
+			due, err := parseTodoistDate(item.Due.Date)
+			if err != nil {
+				skipped = append(skipped, ImportSkip{ID: string(item.ID), Reason: err.Error()})
+				continue
+			}
+			task.DueDate = &due
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, skipped, nil
+}
+
+var (
+	// checkboxRe matches a Markdown task list item, "- [x] text".
+	checkboxRe = regexp.MustCompile(`^[-*+]\s+\[([ xX])\](?:\s+|$)`)
+	// bulletRe matches a Markdown list item marker, "- " or "1. ".
+	bulletRe = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
+)
+
+// importText converts plain text, one task per non-empty line. Markdown
+// list markers are dropped, and "- [x]" items are imported as completed.
+// Indentation is ignored. Skipped items are identified by line number.
+func importText(b []byte) ([]Task, []ImportSkip, error) {
+	tasks := []Task{}
+	skipped := []ImportSkip{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var task Task
+		if m := checkboxRe.FindStringSubmatch(line); m != nil {
+			task.Completed = m[1] != " "
+			line = line[len(m[0]):]
+		} else if m := bulletRe.FindString(line); m != "" {
+			line = line[len(m):]
+		}
+		task.Text = strings.TrimSpace(line)
+		if task.Text == "" {
+			skipped = append(skipped, ImportSkip{ID: strconv.Itoa(n), Reason: "empty content"})
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, skipped, sc.Err()
+}
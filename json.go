@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jsonSerializer is the echo JSON serializer. Responses are indented when
+// pretty is set or the request has ?pretty=true, and compact otherwise or
+// with ?pretty=false. Ids are written as strings, which JavaScript clients
+// can hold beyond 2^53, when stringIDs is set or the request has
+// "Prefer: ids=string". Times are written in timeFormat, see timeFormats,
+// or in the format of "Prefer: time=unix". Top-level arrays are wrapped
+// in an object under their listNames key, e.g. {"tasks": [...]}, when
+// namedLists is set or the request accepts "application/json;
+// lists=named", and left bare with "lists=bare".
+type jsonSerializer struct {
+	echo.DefaultJSONSerializer
+	pretty     bool
+	stringIDs  bool
+	timeFormat string
+	namedLists bool
+}
+
+func (s *jsonSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	pretty := s.pretty || indent != ""
+	if v, ok := c.QueryParams()["pretty"]; ok && v[0] != "" {
+		pretty, _ = strconv.ParseBool(v[0])
+	}
+	indent = ""
+	if pretty {
+		indent = "  "
+	}
+	namedLists := s.namedLists
+	switch acceptParam(c, echo.MIMEApplicationJSON, "lists") {
+	case "named":
+		namedLists = true
+	case "bare":
+		namedLists = false
+	}
+	listName := ""
+	if v := reflect.ValueOf(i); namedLists && v.Kind() == reflect.Slice {
+		var ok bool
+		if listName, ok = listNames[v.Type().Elem()]; !ok {
+			listName = "items"
+		}
+	}
+	stringIDs := s.stringIDs || preference(c, "ids") == "string"
+	if stringIDs {
+		c.Response().Header().Add("Preference-Applied", "ids=string")
+	}
+	timeFormat := s.timeFormat
+	if p := preference(c, "time"); p == "rfc3339nano" || timeFormats[p] != nil {
+		c.Response().Header().Add("Preference-Applied", "time="+p)
+		timeFormat = p
+	}
+	if stringIDs || idCipher != nil || timeFormats[timeFormat] != nil {
+		b, err := rewriteJSON(i, func(name string, t reflect.Type, tok json.Token) json.Token {
+			if idCipher != nil {
+				tok = publicIDs(name, t, tok)
+			}
+			if n, ok := tok.(json.Number); ok && stringIDs && idFields[name] && isInt(t) {
+				return n.String()
+			}
+			if str, ok := tok.(string); ok && timeFormats[timeFormat] != nil && t == timeType {
+				if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+					return timeFormats[timeFormat](t)
+				}
+			}
+			return tok
+		})
+		if err != nil {
+			return err
+		}
+		i = b
+	}
+	if listName != "" {
+		i = map[string]any{listName: i}
+	}
+	return s.DefaultJSONSerializer.Serialize(c, i, indent)
+}
+
+// listNames are the keys of the named lists by the type of their
+// elements. Lists of other types are named items.
+var listNames = map[reflect.Type]string{
+	reflect.TypeFor[Task]():     "tasks",
+	reflect.TypeFor[TagCount](): "tags",
+	reflect.TypeFor[Comment]():  "comments",
+	reflect.TypeFor[Template](): "templates",
+	reflect.TypeFor[Activity](): "activities",
+}
+
+// acceptParam returns the named parameter of the mediaType media range of
+// the Accept request header, or an empty string.
+func acceptParam(c echo.Context, mediaType, name string) string {
+	for _, h := range c.Request().Header.Values(echo.HeaderAccept) {
+		for _, r := range strings.Split(h, ",") {
+			t, params, err := mime.ParseMediaType(r)
+			if err == nil && t == mediaType {
+				return strings.ToLower(params[name])
+			}
+		}
+	}
+	return ""
+}
+
+// idFields are the JSON fields of the request and response structs
+// holding an id or a list of ids.
+var idFields = map[string]bool{
+	"id": true, "ids": true, "task_id": true, "comment_id": true, "blocker_id": true, "source_id": true,
+	"blocked_by": true, "blocks": true, "deleted": true, "prev": true, "next": true,
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// timeFormats are the time formats of JSON_TIME_FORMAT and "Prefer: time=",
+// besides the default rfc3339nano of encoding/json: rfc3339 drops the
+// fraction of the seconds, unix is the seconds since the epoch and unix_ms
+// the milliseconds.
+var timeFormats = map[string]func(time.Time) json.Token{
+	"rfc3339": func(t time.Time) json.Token { return t.Format(time.RFC3339) },
+	"unix":    func(t time.Time) json.Token { return json.Number(strconv.FormatInt(t.Unix(), 10)) },
+	"unix_ms": func(t time.Time) json.Token { return json.Number(strconv.FormatInt(t.UnixMilli(), 10)) },
+}
+
+// rewriteJSON returns the JSON document of v with the values replaced by
+// fn. fn is given the name of the struct field holding the value, or
+// holding the array it is in, and t the Go type of the value, or of the
+// elements of the array. The type is nil within values whose type is not
+// known, such as the maps of any of the task metadata, so that the data
+// of the clients is passed on as is. The document is rewritten token by
+// token so that the fields keep the order of the structs.
+func rewriteJSON(v any, fn func(name string, t reflect.Type, tok json.Token) json.Token) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return rewriteDocument(b, reflect.TypeOf(v), fn)
+}
+
+// rewriteDocument is rewriteJSON for the JSON document b of a value of
+// the type top.
+func rewriteDocument(b []byte, top reflect.Type, fn func(name string, t reflect.Type, tok json.Token) json.Token) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var out bytes.Buffer
+	fields := map[reflect.Type]map[string]reflect.Type{}
+	// scopes holds for each open object or array whether a token was
+	// written in it, and for objects whether the next token is a key and
+	// the current key. typ is the type of the object or array, and
+	// name and elem the struct field and the type of the values in it.
+	type scope struct {
+		object, key, written bool
+		name                 string
+		typ, elem            reflect.Type
+	}
+	var scopes []scope
+	top = derefType(top)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tok == json.Delim('}') || tok == json.Delim(']') {
+			out.WriteRune(rune(tok.(json.Delim)))
+			scopes = scopes[:len(scopes)-1]
+			continue
+		}
+		name, typ := "", top
+		if n := len(scopes); n > 0 {
+			sc := &scopes[n-1]
+			if sc.written && (!sc.object || sc.key) {
+				out.WriteByte(',')
+			}
+			sc.written = true
+			if sc.object && sc.key {
+				k := tok.(string)
+				sc.key, sc.name, sc.elem = false, "", nil
+				switch {
+				case sc.typ != nil && sc.typ.Kind() == reflect.Struct:
+					if fields[sc.typ] == nil {
+						fields[sc.typ] = jsonFields(sc.typ)
+					}
+					if ft, ok := fields[sc.typ][strings.ToLower(k)]; ok {
+						sc.name, sc.elem = k, derefType(ft)
+					}
+				case sc.typ != nil && sc.typ.Kind() == reflect.Map:
+					sc.elem = derefType(sc.typ.Elem())
+				}
+				writeJSON(&out, k)
+				out.WriteByte(':')
+				continue
+			}
+			name, typ = sc.name, sc.elem
+			sc.key = sc.object
+		}
+		if d, ok := tok.(json.Delim); ok {
+			out.WriteRune(rune(d))
+			sc := scope{object: d == '{', key: d == '{', name: name, typ: typ}
+			if d == '[' && typ != nil && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+				sc.elem = derefType(typ.Elem())
+			} else if d == '[' {
+				sc.name = ""
+			}
+			scopes = append(scopes, sc)
+			continue
+		}
+		switch tok := fn(name, typ, tok).(type) {
+		case json.Number:
+			out.WriteString(tok.String())
+		default:
+			writeJSON(&out, tok)
+		}
+	}
+}
+
+var jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+// derefType returns the type pointed to by t, or nil when the JSON of its
+// values is not known from t: for interfaces, which can hold any value,
+// and for types other than structs marshaling themselves, such as
+// json.RawMessage.
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface ||
+		t.Kind() != reflect.Struct && (t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)) {
+		return nil
+	}
+	return t
+}
+
+// isInt reports whether t is an integer type.
+func isInt(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// writeJSON writes the JSON encoding of a decoded token to buf.
+func writeJSON(buf *bytes.Buffer, v any) {
+	b, _ := json.Marshal(v)
+	buf.Write(b)
+}
+
+// strictBinder is the echo binder. When strict is set, JSON bodies with
+// fields the target does not have are rejected instead of the fields being
+// ignored. Under TASK_ID_SCHEME=uuid the ids of JSON bodies are read with
+// parseID.
+type strictBinder struct {
+	echo.DefaultBinder
+	strict bool
+}
+
+func (b *strictBinder) Bind(i interface{}, c echo.Context) error {
+	req := c.Request()
+	if !b.strict && idCipher == nil || req.ContentLength == 0 || !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return b.DefaultBinder.Bind(i, c)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	if idCipher != nil {
+		var idErr error
+		body, err = rewriteDocument(body, reflect.TypeOf(i), internalIDs(&idErr))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+		}
+		if idErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, idErr)
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	if err := b.DefaultBinder.Bind(i, c); err != nil {
+		return err
+	}
+	if !b.strict {
+		return nil
+	}
+	return checkFields(body, i)
+}
+
+// checkFields returns an error listing the fields of the JSON document b
+// that v has no field to decode into.
+func checkFields(b []byte, v any) error {
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	if fields := unknownFields(reflect.TypeOf(v), doc, ""); len(fields) > 0 {
+		slices.Sort(fields)
+		return echo.NewHTTPError(http.StatusBadRequest, newError("unknown_fields", strings.Join(fields, ", ")))
+	}
+	return nil
+}
+
+var jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+
+// jsonFields returns the fields of the struct type t by lower-cased JSON
+// name, as encoding/json matches them case-insensitively.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Tag.Get("json") == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	return fields
+}
+
+// unknownFields returns the paths of the object keys in v that have no
+// field in t. Maps and types decoding themselves accept any key.
+func unknownFields(t reflect.Type, v any, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+	var unknown []string
+	switch v := v.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := jsonFields(t)
+		for k, x := range v {
+			ft, ok := fields[strings.ToLower(k)]
+			if !ok {
+				unknown = append(unknown, path+k)
+				continue
+			}
+			unknown = append(unknown, unknownFields(ft, x, path+k+".")...)
+		}
+	case []any:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i, x := range v {
+			unknown = append(unknown, unknownFields(t.Elem(), x, path+strconv.Itoa(i)+".")...)
+		}
+	}
+	return unknown
+}
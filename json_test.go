@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serialize returns the body written by the jsonSerializer s for v, with
+// the Prefer header of the request set to prefer.
+func serialize(t *testing.T, s *jsonSerializer, prefer string, v any) string {
+	t.Helper()
+	e := echo.New()
+	e.JSONSerializer = s
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+	rec := httptest.NewRecorder()
+	if err := e.NewContext(req, rec).JSON(http.StatusOK, v); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Body.String()
+}
+
+func TestJSONStable(t *testing.T) {
+	at := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	task := Task{
+		ID:        42,
+		Text:      "write report",
+		Rank:      "i",
+		DueDate:   &at,
+		Priority:  2,
+		Metadata:  map[string]any{"tags": []any{"work"}, "project": "q2", "id": 7, "at": "2024-01-01T00:00:00Z"},
+		CreatedAt: at,
+		UpdatedAt: at,
+		Checklist: []ChecklistItem{{Text: "outline", Done: true}, {Text: "draft"}},
+		BlockedBy: []int64{3, 1},
+	}
+	grouped := map[string][]Task{"work": {task}, "": {task}, "home": {}}
+	tests := []struct {
+		name   string
+		s      *jsonSerializer
+		prefer string
+		v      any
+		want   string
+	}{
+		{
+			name: "task",
+			s:    &jsonSerializer{},
+			v:    task,
+			want: `{"id":42,"text":"write report","completed":false,"rank":"i","due_date":"2024-05-01T09:30:00Z","color":"","starred":false,"priority":2,"metadata":{"at":"2024-01-01T00:00:00Z","id":7,"project":"q2","tags":["work"]},"created_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z","checklist":[{"text":"outline","done":true},{"text":"draft","done":false}],"blocked_by":[3,1],"checklist_progress":{"done":1,"total":2}}` + "\n",
+		},
+		{
+			name:   "string ids and unix times",
+			s:      &jsonSerializer{timeFormat: "unix"},
+			prefer: "ids=string",
+			v:      task,
+			want:   `{"id":"42","text":"write report","completed":false,"rank":"i","due_date":1714555800,"color":"","starred":false,"priority":2,"metadata":{"at":"2024-01-01T00:00:00Z","id":7,"project":"q2","tags":["work"]},"created_at":1714555800,"updated_at":1714555800,"checklist":[{"text":"outline","done":true},{"text":"draft","done":false}],"blocked_by":["3","1"],"checklist_progress":{"done":1,"total":2}}` + "\n",
+		},
+		{
+			name: "grouped",
+			s:    &jsonSerializer{},
+			v:    grouped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := serialize(t, tt.s, tt.prefer, tt.v)
+			if tt.want != "" && first != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", first, tt.want)
+			}
+			for range 20 {
+				if got := serialize(t, tt.s, tt.prefer, tt.v); got != first {
+					t.Fatalf("output changed between runs:\n%s\n%s", first, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/text/language"
+)
+
+// concurrencyLimit returns a middleware that allows at most n handlers
+// touching the database to run at once. Requests over the limit are
+// answered with 503 immediately instead of waiting for a free slot.
+func concurrencyLimit(n int) echo.MiddlewareFunc {
+	sem := make(chan struct{}, n)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// static assets are served from memory.
+			if c.Path() == "/*" {
+				return next(c)
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				return next(c)
+			default:
+				c.Response().Header().Set("Retry-After", "1")
+				return c.JSON(http.StatusServiceUnavailable, msg(c, "too_many_requests"))
+			}
+		}
+	}
+}
+
+// contextTimeout returns a middleware cancelling the context of the
+// request after d. Unlike requestTimeout, the handler is expected to pass
+// the context on to its queries, which are then cancelled on the server,
+// and to answer itself when they are.
+func contextTimeout(d time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), d)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// requestTimeout returns a middleware answering 503 when a handler runs for
+// longer than d. The handler is not stopped, only its response is dropped.
+// The NDJSON streams of GET /tasks?format=ndjson are not limited.
+func requestTimeout(d time.Duration) echo.MiddlewareFunc {
+	timeouts := map[language.Tag]echo.MiddlewareFunc{}
+	for _, tag := range languages {
+		timeouts[tag] = middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+			Skipper: func(c echo.Context) bool {
+				return c.QueryParam("format") == "ndjson"
+			},
+			ErrorMessage: translate(tag, "request_timeout"),
+			OnTimeoutRouteErrorHandler: func(err error, c echo.Context) {
+				c.Logger().Error(c.Path(), ": ", err)
+			},
+			Timeout: d,
+		})
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handlers := map[language.Tag]echo.HandlerFunc{}
+		for tag, mw := range timeouts {
+			handlers[tag] = mw(next)
+		}
+		return func(c echo.Context) error {
+			return handlers[requestLanguage(c)](c)
+		}
+	}
+}
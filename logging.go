@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// requestLogger logs every request that failed and a sampleRate fraction
+// of the successful ones, with the URI and the error redacted by redact.
+func requestLogger(sampleRate float64, redact *redactor) echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogMethod:   true,
+		LogURI:      true,
+		LogStatus:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogError:    true,
+		HandleError: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			failed := v.Error != nil || v.Status >= 400
+			if !failed && rand.Float64() >= sampleRate {
+				return nil
+			}
+			attrs := []slog.Attr{
+				slog.String("method", v.Method),
+				slog.String("uri", redact.uri(v.URI)),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.String("remote_ip", v.RemoteIP),
+			}
+			if v.Error != nil {
+				attrs = append(attrs, slog.String("error", redact.text(v.Error.Error())))
+				slog.LogAttrs(c.Request().Context(), slog.LevelError, "request", attrs...)
+			} else {
+				slog.LogAttrs(c.Request().Context(), slog.LevelInfo, "request", attrs...)
+			}
+			return nil
+		},
+	})
+}
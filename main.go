@@ -1,24 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"log"
-	"log/slog"
+	"maps"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/graph-gophers/graphql-go/relay"
 	"github.com/labstack/echo/v4"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
-	"github.com/uptrace/bun/extra/bundebug"
-	"github.com/uptrace/bun/extra/bunslog"
 )
 
 const name = "go-todoapp"
@@ -36,112 +49,2530 @@ type Task struct {
 	ID        int64  `bun:"id,pk,autoincrement" json:"id"`
 	Text      string `bun:"text,notnull" json:"text"`
 	Completed bool   `bun:"completed,default:false" json:"completed"`
+	// CompletedAt is when the task was last completed.
+	CompletedAt *time.Time     `bun:"completed_at" json:"completed_at,omitempty"`
+	Rank        string         `bun:"rank,notnull,default:''" json:"rank"`
+	DueDate     *time.Time     `bun:"due_date" json:"due_date,omitempty"`
+	Color       string         `bun:"color,notnull,default:''" json:"color"`
+	Starred     bool           `bun:"starred,notnull,default:false" json:"starred"`
+	Priority    int            `bun:"priority,notnull,default:0" json:"priority"`
+	Metadata    map[string]any `bun:"metadata,type:jsonb" json:"metadata,omitempty"`
+	CreatedAt   time.Time      `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt   time.Time      `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+	DeletedAt   time.Time      `bun:"deleted_at,soft_delete,nullzero" json:"-"`
+	// EstimateMinutes is the expected effort of the task.
+	EstimateMinutes *int `bun:"estimate_minutes" json:"estimate_minutes,omitempty"`
+	// ReminderMinutes is how long before the due date the task is
+	// reminded, REMINDER_OFFSET when nil.
+	ReminderMinutes *int `bun:"reminder_minutes" json:"reminder_minutes,omitempty"`
+	// StartDate defers the task until it becomes actionable, see
+	// ?active_on=.
+	StartDate *time.Time `bun:"start_date" json:"start_date,omitempty"`
+	// WaitingFor is who the task is waiting on. A pending task with one is
+	// waiting, see ?status=waiting.
+	WaitingFor string `bun:"waiting_for,notnull,default:''" json:"waiting_for,omitempty"`
+	// ShareVersion revokes the share links of the task made before it was
+	// last incremented, see shareClaims.
+	ShareVersion int `bun:"share_version,notnull,default:0" json:"-"`
+	// ExternalID is the id of the task in a system syncing it. It is
+	// unique among the tasks, deleted ones included, see POST
+	// /tasks?upsert=true.
+	ExternalID string `bun:"external_id,nullzero,unique" json:"external_id,omitempty"`
+	// Checklist are the steps within the task. The task JSON also has
+	// their checklist_progress.
+	Checklist []ChecklistItem `bun:"checklist,type:jsonb" json:"checklist,omitempty"`
+	// Highlight is the text with the words matching ?q= wrapped in
+	// <mark> and </mark> when ?highlight=true. The text is HTML-escaped.
+	Highlight string `bun:"highlight,scanonly" json:"highlight,omitempty"`
+	// Warnings are the non-fatal problems found when creating the task.
+	Warnings []string `bun:"-" json:"warnings,omitempty"`
+
+	// BlockedBy and Blocks are the ids of the tasks this task depends on
+	// and the tasks depending on it.
+	BlockedBy []int64 `bun:"-" json:"blocked_by,omitempty"`
+	Blocks    []int64 `bun:"-" json:"blocks,omitempty"`
+	// Preview is the text cut to PREVIEW_LENGTH characters in list
+	// responses, set only when the text is longer.
+	Preview string `bun:"-" json:"preview,omitempty"`
+	// CommentCount is the number of comments on the task.
+	CommentCount int `bun:"-" json:"comment_count,omitempty"`
+	// Comments are only loaded with ?include=comments.
+	Comments []Comment `bun:"rel:has-many,join:id=task_id" json:"comments,omitempty"`
+}
+
+// trackCompletion sets or clears CompletedAt when the completion of the
+// task changed from was.
+func (t *Task) trackCompletion(was bool, now time.Time) {
+	switch {
+	case t.Completed && !was:
+		t.CompletedAt = &now
+	case !t.Completed:
+		t.CompletedAt = nil
+	}
+}
+
+// TaskUpdate is the body of POST /tasks/:id. Only the fields present in the
+// request are changed.
+type TaskUpdate struct {
+	Completed *bool   `json:"completed"`
+	Color     *string `json:"color"`
+	Starred   *bool   `json:"starred"`
+	Priority  *int    `json:"priority"`
+	// Metadata replaces the whole metadata object. Send {} to clear it.
+	Metadata map[string]any `json:"metadata"`
+	// EstimateMinutes replaces the estimate. It cannot be cleared.
+	EstimateMinutes *int `json:"estimate_minutes"`
+	// ReminderMinutes replaces the reminder offset. It cannot be cleared.
+	ReminderMinutes *int `json:"reminder_minutes"`
+	// StartDate replaces the start date. It cannot be cleared.
+	StartDate *time.Time `json:"start_date"`
+	// WaitingFor replaces who the task is waiting on. Send "" to clear it.
+	WaitingFor *string `json:"waiting_for"`
+}
+
+func (u *TaskUpdate) apply(t *Task) {
+	if u.Completed != nil {
+		t.Completed = *u.Completed
+	}
+	if u.Color != nil {
+		t.Color = *u.Color
+	}
+	if u.Starred != nil {
+		t.Starred = *u.Starred
+	}
+	if u.Priority != nil {
+		t.Priority = *u.Priority
+	}
+	if u.EstimateMinutes != nil {
+		t.EstimateMinutes = u.EstimateMinutes
+	}
+	if u.ReminderMinutes != nil {
+		t.ReminderMinutes = u.ReminderMinutes
+	}
+	if u.StartDate != nil {
+		t.StartDate = u.StartDate
+	}
+	if u.WaitingFor != nil {
+		t.WaitingFor = *u.WaitingFor
+	}
+	if u.Metadata != nil {
+		t.Metadata = u.Metadata
+	}
+}
+
+// Changes is the response of GET /tasks/changes.
+type Changes struct {
+	Tasks   []Task    `json:"tasks"`
+	Deleted []int64   `json:"deleted"`
+	Now     time.Time `json:"now"`
+}
+
+// Page is the response of GET /tasks when the client opts in to the
+// envelope with ?envelope=true or the X-Envelope header.
+type Page struct {
+	Data       []Task     `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Pagination describes the window of a Page. Limit is zero when the whole
+// list was requested.
+type Pagination struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// taskGroupKeys are the fields GET /tasks/grouped can group by.
+var taskGroupKeys = map[string]func(*Task) string{
+	"completed": func(t *Task) string {
+		return strconv.FormatBool(t.Completed)
+	},
+	"color": func(t *Task) string {
+		if t.Color == "" {
+			return "none"
+		}
+		return t.Color
+	},
+	"due_date": func(t *Task) string {
+		if t.DueDate == nil {
+			return "none"
+		}
+		return t.DueDate.Format(time.DateOnly)
+	},
+	"priority": func(t *Task) string {
+		return strconv.Itoa(t.Priority)
+	},
+}
+
+// taskColumns are added to an existing Task table that was created by an
+// older version.
+var taskColumns = []string{
+	`"rank" VARCHAR NOT NULL DEFAULT ''`,
+	`"due_date" TIMESTAMPTZ`,
+	`"color" VARCHAR NOT NULL DEFAULT ''`,
+	`"starred" BOOLEAN NOT NULL DEFAULT false`,
+	`"metadata" JSONB`,
+	`"priority" INTEGER NOT NULL DEFAULT 0`,
+	`"deleted_at" TIMESTAMPTZ`,
+	`"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp`,
+	`"updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp`,
+	`"completed_at" TIMESTAMPTZ`,
+	`"estimate_minutes" INTEGER`,
+	`"reminder_minutes" INTEGER`,
+	`"checklist" JSONB`,
+	`"start_date" TIMESTAMPTZ`,
+	`"waiting_for" VARCHAR NOT NULL DEFAULT ''`,
+	`"share_version" INTEGER NOT NULL DEFAULT 0`,
+	`"external_id" VARCHAR UNIQUE`,
+}
+
+// taskIndexes are created on the Task table for the filters and orderings
+// used by the handlers.
+var taskIndexes = []struct {
+	name  string
+	expr  string
+	using string
+}{
+	{name: "task_completed_idx", expr: "completed"},
+	{name: "task_rank_idx", expr: `rank COLLATE "C"`},
+	{name: "task_due_date_idx", expr: "due_date"},
+	{name: "task_text_idx", expr: "to_tsvector('simple', text)", using: "GIN"},
+	{name: "task_updated_at_idx", expr: "updated_at"},
+	{name: "task_completed_at_idx", expr: "completed_at"},
+	{name: "task_metadata_idx", expr: "metadata jsonb_path_ops", using: "GIN"},
+	{name: "task_text_fold_idx", expr: foldedText},
+	{name: "task_start_date_idx", expr: "start_date"},
+}
+
+// migrate brings the Task table up to date with the Task model.
+func migrate(ctx context.Context, bundb *bun.DB) error {
+	_, err := bundb.NewCreateTable().Model((*Task)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	for _, col := range taskColumns {
+		_, err = bundb.NewAddColumn().Model((*Task)(nil)).ColumnExpr(col).IfNotExists().Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// task_text_fold_idx replaces it.
+	_, err = bundb.NewDropIndex().Index("task_text_lower_idx").IfExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	for _, idx := range taskIndexes {
+		q := bundb.NewCreateIndex().Model((*Task)(nil)).Index(idx.name).ColumnExpr(idx.expr).IfNotExists()
+		if idx.using != "" {
+			q = q.Using(idx.using)
+		}
+		if _, err = q.Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err = migrateDependencies(ctx, bundb); err != nil {
+		return err
+	}
+	if err = migrateComments(ctx, bundb); err != nil {
+		return err
+	}
+	if err = migrateReminders(ctx, bundb); err != nil {
+		return err
+	}
+	for _, model := range []any{(*Template)(nil), (*Preferences)(nil), (*APIQuota)(nil)} {
+		_, err = bundb.NewCreateTable().Model(model).IfNotExists().Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// give tasks created before ranks existed a position after the others.
+	var ids []int64
+	err = bundb.NewSelect().Model((*Task)(nil)).Column("id").Where("rank = ''").Order("id").Scan(ctx, &ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		rank, err := lastRank(ctx, bundb)
+		if err != nil {
+			return err
+		}
+		_, err = bundb.NewUpdate().Model((*Task)(nil)).Set("rank = ?", rankBetween(rank, "")).Where("id = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastRank returns the greatest rank in use, or an empty string when there
+// are no tasks.
+func lastRank(ctx context.Context, db bun.IDB) (string, error) {
+	var ranks []string
+	err := db.NewSelect().Model((*Task)(nil)).Column("rank").OrderExpr(`rank COLLATE "C" DESC`).Limit(1).Scan(ctx, &ranks)
+	if err != nil || len(ranks) == 0 {
+		return "", err
+	}
+	return ranks[0], nil
+}
+
+// databaseURL returns the Postgres DSN. DATABASE_URL wins when it is set;
+// otherwise the DSN is built from DB_HOST, DB_PORT, DB_USER, DB_PASSWORD,
+// DB_NAME and DB_SSLMODE.
+func databaseURL() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   net.JoinHostPort(envOr("DB_HOST", "localhost"), envOr("DB_PORT", "5432")),
+		Path:   "/" + os.Getenv("DB_NAME"),
+	}
+	if user := os.Getenv("DB_USER"); user != "" {
+		if password, ok := os.LookupEnv("DB_PASSWORD"); ok {
+			u.User = url.UserPassword(user, password)
+		} else {
+			u.User = url.User(user)
+		}
+	}
+	if sslmode := os.Getenv("DB_SSLMODE"); sslmode != "" {
+		u.RawQuery = url.Values{"sslmode": {sslmode}}.Encode()
+	}
+	return u.String()
+}
+
+// errTaskLimit is returned when creating a task would exceed MAX_TASKS.
+var errTaskLimit = errors.New("task limit reached")
+
+// envOr returns the value of the environment variable named by key, or def
+// when it is unset or empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// maxIDs is the maximum number of ids accepted in a single request, see
+// MAX_IDS.
+var maxIDs = 100
+
+// idChunkSize is the most ids put in one IN (...) list. Statements on
+// longer lists are run once per chunk.
+const idChunkSize = 100
+
+// parseIDs parses a comma separated list of task ids. Duplicated ids are
+// dropped, keeping the first occurrence.
+func parseIDs(s string) ([]int64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) > maxIDs {
+		return nil, newError("too_many_ids", maxIDs)
+	}
+	ids := make([]int64, 0, len(fields))
+	seen := map[int64]bool{}
+	for _, f := range fields {
+		id, err := parseID(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// preference returns the value of the named preference sent in the
+// Prefer request header (RFC 7240), or an empty string.
+func preference(c echo.Context, name string) string {
+	for _, h := range c.Request().Header.Values("Prefer") {
+		for _, p := range strings.Split(h, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, name) {
+				return strings.ToLower(strings.Trim(v, `"`))
+			}
+		}
+	}
+	return ""
+}
+
+// taskETag returns the weak ETag of the JSON, or Markdown, representation
+// of the task, which changes with its updated_at.
+func taskETag(task *Task, markdown bool) string {
+	etag := fmt.Sprintf(`W/"%s-%d`, formatID(task.ID), task.UpdatedAt.UnixNano())
+	if markdown {
+		etag += "-md"
+	}
+	return etag + `"`
+}
+
+// taskResponse writes the task honoring the Prefer header. With
+// return=minimal only the Location of the task is sent, which created
+// tasks always get.
+func taskResponse(c echo.Context, code int, task *Task) error {
+	if code == http.StatusCreated {
+		c.Response().Header().Set("Location", "/tasks/"+formatID(task.ID))
+	}
+	switch preference(c, "return") {
+	case "minimal":
+		c.Response().Header().Set("Preference-Applied", "return=minimal")
+		c.Response().Header().Set("Location", "/tasks/"+formatID(task.ID))
+		if code == http.StatusCreated {
+			return c.NoContent(code)
+		}
+		return c.NoContent(http.StatusNoContent)
+	case "representation":
+		c.Response().Header().Set("Preference-Applied", "return=representation")
+	}
+	return c.JSON(code, task)
+}
+
+// storeError writes the response for an error returned by a TaskStore.
+// Errors from the update function are sent as echo.HTTPError.
+func storeError(c echo.Context, err error) error {
+	var he *echo.HTTPError
+	var le *priorityLimitError
+	var de *duplicateTaskError
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		return c.JSON(http.StatusNotFound, msg(c, "task_not_found"))
+	case errors.As(err, &de):
+		return c.JSON(http.StatusConflict, msg(c, "task_duplicate", de.task.ID))
+	case errors.Is(err, errTaskBlocked):
+		return c.JSON(http.StatusConflict, msg(c, "task_blocked"))
+	case errors.Is(err, errExternalIDTaken):
+		return c.JSON(http.StatusConflict, msg(c, "external_id_taken"))
+	case errors.As(err, &le):
+		return c.JSON(http.StatusConflict, msg(c, "priority_limit", le.limit, le.priority))
+	case errors.As(err, &he):
+		return c.String(he.Code, errorMessage(c, he.Message))
+	case dbUnavailable(err):
+		c.Logger().Error(err)
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusServiceUnavailable, msg(c, "database_unavailable"))
+	}
+	c.Logger().Error(err)
+	return c.JSON(http.StatusInternalServerError, errorMessage(c, err))
+}
+
+func main() {
+	e, closeDB, err := newServer()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer closeDB()
+	e.Logger.Fatal(e.Start(":8989"))
 }
 
-func main() {
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
+// newServer returns the server configured by the environment, and the
+// function closing its database.
+func newServer() (*echo.Echo, func(), error) {
+	maxTasks, err := strconv.ParseInt(envOr("MAX_TASKS", "0"), 10, 64)
+	if err != nil {
+		log.Fatal("MAX_TASKS: ", err)
+	}
+	// PRIORITY_LIMITS caps the open tasks per priority, e.g. "3:2,2:5".
+	priorityLimits, err := parsePriorityLimits(os.Getenv("PRIORITY_LIMITS"))
+	if err != nil {
+		log.Fatal("PRIORITY_LIMITS: ", err)
+	}
+	logSampleRate, err := strconv.ParseFloat(envOr("LOG_SAMPLE_RATE", "1"), 64)
+	if err != nil || logSampleRate < 0 || logSampleRate > 1 {
+		log.Fatal("LOG_SAMPLE_RATE: must be between 0 and 1")
+	}
+	// DELETE_RESPONSE selects the body of a successful DELETE: "none" for
+	// 204 No Content, "id" for the deleted id or "task" for the deleted task.
+	deleteResponse := envOr("DELETE_RESPONSE", "none")
+	if deleteResponse != "none" && deleteResponse != "id" && deleteResponse != "task" {
+		log.Fatal("DELETE_RESPONSE: must be none, id or task")
+	}
+	staticMaxAge, err := time.ParseDuration(envOr("STATIC_MAX_AGE", "0s"))
+	if err != nil {
+		log.Fatal("STATIC_MAX_AGE: ", err)
+	}
+	// NOT_FOUND_PAGE and ERROR_PAGE are the assets served for missing
+	// frontend files and server errors. Set them empty for plain text.
+	notFoundPage, ok := os.LookupEnv("NOT_FOUND_PAGE")
+	if !ok {
+		notFoundPage = "404.html"
+	}
+	// LANDING_REDIRECT redirects / to the URL, a path such as /app or an
+	// absolute URL, with 302 Found instead of serving index.html.
+	landingRedirect := os.Getenv("LANDING_REDIRECT")
+	if landingRedirect != "" {
+		if u, err := url.Parse(landingRedirect); err != nil || (u.Scheme == "" && !strings.HasPrefix(u.Path, "/")) {
+			log.Fatal("LANDING_REDIRECT: must be an absolute URL or path")
+		}
+	}
+	errorPage, ok := os.LookupEnv("ERROR_PAGE")
+	if !ok {
+		errorPage = "500.html"
+	}
+	appEnv := envOr("APP_ENV", "production")
+	jsonPretty, _ := strconv.ParseBool(os.Getenv("JSON_PRETTY"))
+	// JSON_STRING_IDS writes ids as strings for every request, not only
+	// the ones sending "Prefer: ids=string".
+	jsonStringIDs, _ := strconv.ParseBool(os.Getenv("JSON_STRING_IDS"))
+	// JSON_TIME_FORMAT is the format of the times of the responses:
+	// rfc3339nano (the default), rfc3339, unix or unix_ms, see
+	// timeFormats. Requests can ask for another one with "Prefer:
+	// time=unix". Request bodies always take RFC 3339.
+	jsonTimeFormat := envOr("JSON_TIME_FORMAT", "rfc3339nano")
+	if _, ok := timeFormats[jsonTimeFormat]; !ok && jsonTimeFormat != "rfc3339nano" {
+		log.Fatal("JSON_TIME_FORMAT: must be rfc3339nano, rfc3339, unix or unix_ms")
+	}
+	// JSON_LISTS=named wraps the lists of the responses in an object, e.g.
+	// {"tasks": [...]}, for clients that cannot decode a top-level array.
+	// Requests can ask for either with "Accept: application/json;
+	// lists=named" or "lists=bare".
+	var jsonNamedLists bool
+	switch jsonLists := envOr("JSON_LISTS", "bare"); jsonLists {
+	case "bare":
+	case "named":
+		jsonNamedLists = true
+	default:
+		log.Fatal("JSON_LISTS: must be bare or named")
+	}
+	// STRICT_JSON rejects request bodies with unknown fields.
+	strictJSON, _ := strconv.ParseBool(os.Getenv("STRICT_JSON"))
+	maxConcurrency, err := strconv.Atoi(envOr("MAX_CONCURRENCY", "0"))
+	if err != nil {
+		log.Fatal("MAX_CONCURRENCY: ", err)
+	}
+	timeout, err := time.ParseDuration(envOr("REQUEST_TIMEOUT", "0s"))
+	if err != nil {
+		log.Fatal("REQUEST_TIMEOUT: ", err)
+	}
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	allowCancel, _ := strconv.ParseBool(os.Getenv("ADMIN_ALLOW_CANCEL"))
+	// DB_RETRIES is the number of times a transaction failing with a
+	// serialization failure, a deadlock or a lost connection is retried,
+	// waiting DB_RETRY_BACKOFF doubled at each retry.
+	txRetries, err = strconv.Atoi(envOr("DB_RETRIES", "3"))
+	if err != nil || txRetries < 0 {
+		log.Fatal("DB_RETRIES: must be a positive number")
+	}
+	txBackoff, err = time.ParseDuration(envOr("DB_RETRY_BACKOFF", "50ms"))
+	if err != nil || txBackoff <= 0 {
+		log.Fatal("DB_RETRY_BACKOFF: must be a positive duration")
+	}
+	// API_KEYS is a comma separated list of keys required by every route
+	// but publicRoutes and the ones added by AUTH_PUBLIC_ROUTES. The
+	// frontend does not send keys, so it only works without API_KEYS.
+	var apiKeys []string
+	if s := os.Getenv("API_KEYS"); s != "" {
+		apiKeys = strings.Split(s, ",")
+	}
+	// API_QUOTA is the number of writes each of the API_KEYS can make per
+	// day. Zero does not limit them.
+	apiQuotaLimit, err := strconv.Atoi(envOr("API_QUOTA", "0"))
+	if err != nil || apiQuotaLimit < 0 {
+		log.Fatal("API_QUOTA: must be a positive number")
+	}
+	public := publicRoutes
+	if s := os.Getenv("AUTH_PUBLIC_ROUTES"); s != "" {
+		public = append(slices.Clone(public), strings.Split(s, ",")...)
+	}
+	// COALESCE_LISTS shares the database query between concurrent identical
+	// list requests, for COALESCE_TIMEOUT at most. See coalescingStore.
+	coalesce, _ := strconv.ParseBool(os.Getenv("COALESCE_LISTS"))
+	coalesceTimeout, err := time.ParseDuration(envOr("COALESCE_TIMEOUT", "30s"))
+	if err != nil || coalesceTimeout <= 0 {
+		log.Fatal("COALESCE_TIMEOUT: must be a positive duration")
+	}
+	// DEFAULT_PRIORITY and DEFAULT_DUE_IN (e.g. "72h") are applied to new
+	// tasks that do not set a priority or a due date.
+	var defaults TaskDefaults
+	defaults.Priority, err = strconv.Atoi(envOr("DEFAULT_PRIORITY", "0"))
+	if err != nil || !validPriority(defaults.Priority) {
+		log.Fatal("DEFAULT_PRIORITY: must be between 0 and ", maxPriority)
+	}
+	defaults.DueIn, err = time.ParseDuration(envOr("DEFAULT_DUE_IN", "0s"))
+	if err != nil || defaults.DueIn < 0 {
+		log.Fatal("DEFAULT_DUE_IN: must be a positive duration")
+	}
+	// UNDO_WINDOW is how long deleted tasks are retained and can be brought
+	// back with POST /tasks/undo. Older deletions are purged for good.
+	undoWindow, err := time.ParseDuration(envOr("UNDO_WINDOW", "5m"))
+	if err != nil || undoWindow < 0 {
+		log.Fatal("UNDO_WINDOW: must be a positive duration")
+	}
+	// READY_CHECK_URLS and READY_CHECK_DISKS add dependencies to the
+	// database checked by GET /readyz, each within READY_TIMEOUT.
+	readyChecks, err := parseReadyChecks(os.Getenv("READY_CHECK_URLS"), os.Getenv("READY_CHECK_DISKS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	readyTimeout, err := time.ParseDuration(envOr("READY_TIMEOUT", "2s"))
+	if err != nil || readyTimeout <= 0 {
+		log.Fatal("READY_TIMEOUT: must be a positive duration")
+	}
+	// MAX_TASK_LENGTH is the most characters the text of a task can have.
+	// Zero means no limit.
+	maxTextLength, err = strconv.Atoi(envOr("MAX_TASK_LENGTH", "0"))
+	if err != nil || maxTextLength < 0 {
+		log.Fatal("MAX_TASK_LENGTH: must be a positive number")
+	}
+	// DEFAULT_PAGE_SIZE is the number of tasks GET /tasks returns when
+	// neither ?limit= nor the preferences set one. Zero returns them all.
+	defaultPageSize, err := strconv.Atoi(envOr("DEFAULT_PAGE_SIZE", "0"))
+	if err != nil || defaultPageSize < 0 {
+		log.Fatal("DEFAULT_PAGE_SIZE: must be a positive number")
+	}
+	// PREVIEW_LENGTH is the number of characters of the preview of long
+	// task texts in list responses. Zero disables previews.
+	previewLength, err := strconv.Atoi(envOr("PREVIEW_LENGTH", "80"))
+	if err != nil || previewLength < 0 {
+		log.Fatal("PREVIEW_LENGTH: must be a positive number")
+	}
+	// DUPLICATE_TASKS selects what creating a task with the text of an open
+	// task, as compared by sameText, does: "allow" creates it,
+	// "reject" responds 409 Conflict and "existing" makes POST /tasks
+	// return the open task instead, with 200 OK.
+	duplicateTasks := envOr("DUPLICATE_TASKS", "allow")
+	if duplicateTasks != "allow" && duplicateTasks != "reject" && duplicateTasks != "existing" {
+		log.Fatal("DUPLICATE_TASKS: must be allow, reject or existing")
+	}
+	// CALDAV_URL is a CalDAV calendar collection the tasks with a due date
+	// are synced to every CALDAV_SYNC_INTERVAL, authenticated with
+	// CALDAV_USERNAME and CALDAV_PASSWORD. See caldavSync.
+	caldavURL := os.Getenv("CALDAV_URL")
+	caldavInterval, err := time.ParseDuration(envOr("CALDAV_SYNC_INTERVAL", "1m"))
+	if err != nil || caldavInterval <= 0 {
+		log.Fatal("CALDAV_SYNC_INTERVAL: must be a positive duration")
+	}
+	// DB_STATEMENT_TIMEOUT and DB_IDLE_IN_TRANSACTION_TIMEOUT set the
+	// statement_timeout and idle_in_transaction_session_timeout of every
+	// database connection, so a stuck query or a transaction left open
+	// cannot block the others for long. Zero keeps the server defaults.
+	dbTimeouts := map[string]time.Duration{}
+	for env, name := range map[string]string{
+		"DB_STATEMENT_TIMEOUT":           "statement_timeout",
+		"DB_IDLE_IN_TRANSACTION_TIMEOUT": "idle_in_transaction_session_timeout",
+	} {
+		d, err := time.ParseDuration(envOr(env, "0s"))
+		if err != nil || d < 0 {
+			log.Fatal(env, ": must be a positive duration")
+		}
+		dbTimeouts[name] = d
+	}
+	// DB_CONN_MAX_IDLE_TIME and DB_CONN_MAX_LIFETIME close pooled
+	// connections idle for, or open for, longer than the durations; zero
+	// keeps them. DB_PING_ON_BORROW=true pings a pooled connection before
+	// each reuse and replaces it when the server does not answer, which
+	// spares the queries the dead connections left by a failover at the
+	// cost of a round trip.
+	connMaxIdleTime, err := time.ParseDuration(envOr("DB_CONN_MAX_IDLE_TIME", "0s"))
+	if err != nil || connMaxIdleTime < 0 {
+		log.Fatal("DB_CONN_MAX_IDLE_TIME: must be a positive duration")
+	}
+	connMaxLifetime, err := time.ParseDuration(envOr("DB_CONN_MAX_LIFETIME", "0s"))
+	if err != nil || connMaxLifetime < 0 {
+		log.Fatal("DB_CONN_MAX_LIFETIME: must be a positive duration")
+	}
+	pingOnBorrow, _ := strconv.ParseBool(os.Getenv("DB_PING_ON_BORROW"))
+	// LOG_REDACT=true keeps personal data out of the request and query
+	// logs: the values of the LOG_REDACT_PARAMS query parameters ("q" by
+	// default) and the string literals of queries are masked, as well as
+	// whatever matches the LOG_REDACT_PATTERN regexp. LOG_REDACT_HASH=true
+	// replaces them by a short hash instead of omitting them.
+	var redact *redactor
+	if ok, _ := strconv.ParseBool(os.Getenv("LOG_REDACT")); ok {
+		redact = &redactor{params: strings.Split(envOr("LOG_REDACT_PARAMS", "q"), ",")}
+		if s := os.Getenv("LOG_REDACT_PATTERN"); s != "" {
+			redact.pattern, err = regexp.Compile(s)
+			if err != nil {
+				log.Fatal("LOG_REDACT_PATTERN: ", err)
+			}
+		}
+		redact.hash, _ = strconv.ParseBool(os.Getenv("LOG_REDACT_HASH"))
+	}
+	// CLEANUP_COMPLETED_AFTER deletes the tasks completed longer ago than
+	// the duration, checked every CLEANUP_INTERVAL. Completed tasks are
+	// kept forever when it is not set.
+	var cleanupRetention time.Duration
+	if s := os.Getenv("CLEANUP_COMPLETED_AFTER"); s != "" {
+		cleanupRetention, err = time.ParseDuration(s)
+		if err != nil || cleanupRetention <= 0 {
+			log.Fatal("CLEANUP_COMPLETED_AFTER: must be a positive duration")
+		}
+	}
+	cleanupInterval, err := time.ParseDuration(envOr("CLEANUP_INTERVAL", "1h"))
+	if err != nil || cleanupInterval <= 0 {
+		log.Fatal("CLEANUP_INTERVAL: must be a positive duration")
+	}
+	// SMTP_ADDR is the host:port of an SMTP server the reminders of the
+	// tasks with a due date are emailed through to REMINDER_EMAIL_TO, from
+	// SMTP_FROM, authenticated with SMTP_USERNAME and SMTP_PASSWORD. Tasks
+	// are reminded REMINDER_OFFSET before they are due unless they have
+	// reminder_minutes, checked every REMINDER_INTERVAL. The subject and
+	// body are the REMINDER_EMAIL_SUBJECT and REMINDER_EMAIL_BODY
+	// text/template templates, executed with the task. See emailReminders.
+	smtpAddr := os.Getenv("SMTP_ADDR")
+	reminderOffset, err := time.ParseDuration(envOr("REMINDER_OFFSET", "1h"))
+	if err != nil || reminderOffset < 0 {
+		log.Fatal("REMINDER_OFFSET: must be a positive duration")
+	}
+	reminderInterval, err := time.ParseDuration(envOr("REMINDER_INTERVAL", "1m"))
+	if err != nil || reminderInterval <= 0 {
+		log.Fatal("REMINDER_INTERVAL: must be a positive duration")
+	}
+	var reminders *emailReminders
+	if smtpAddr != "" {
+		reminders = &emailReminders{
+			addr:   smtpAddr,
+			auth:   smtpAuth(smtpAddr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")),
+			from:   os.Getenv("SMTP_FROM"),
+			to:     os.Getenv("REMINDER_EMAIL_TO"),
+			offset: reminderOffset,
+		}
+		if reminders.from == "" || reminders.to == "" {
+			log.Fatal("SMTP_ADDR: SMTP_FROM and REMINDER_EMAIL_TO are required")
+		}
+		reminders.subject, err = template.New("subject").Parse(envOr("REMINDER_EMAIL_SUBJECT", defaultReminderSubject))
+		if err != nil {
+			log.Fatal("REMINDER_EMAIL_SUBJECT: ", err)
+		}
+		reminders.body, err = template.New("body").Parse(envOr("REMINDER_EMAIL_BODY", defaultReminderBody))
+		if err != nil {
+			log.Fatal("REMINDER_EMAIL_BODY: ", err)
+		}
+	}
+	// QUICKADD_PRIORITY_PREFIX and QUICKADD_TAG_PREFIX start the priority
+	// and tag words of POST /tasks/quickadd, "!" and "#" by default.
+	quick := &quickAdd{
+		priorityPrefix: envOr("QUICKADD_PRIORITY_PREFIX", "!"),
+		tagPrefix:      envOr("QUICKADD_TAG_PREFIX", "#"),
+	}
+	if quick.priorityPrefix == quick.tagPrefix {
+		log.Fatal("QUICKADD_TAG_PREFIX: must differ from QUICKADD_PRIORITY_PREFIX")
+	}
+	// STATS_TIMEOUT cancels the queries of the aggregation endpoints,
+	// /tasks/estimate, /tasks/streak and /tasks/heatmap, running longer
+	// than the duration, answering 503. Zero disables it.
+	statsTimeout, err := time.ParseDuration(envOr("STATS_TIMEOUT", "0s"))
+	if err != nil || statsTimeout < 0 {
+		log.Fatal("STATS_TIMEOUT: must be a positive duration")
+	}
+	// MAX_TAGS is the most tags, the "tags" array of the metadata as set by
+	// POST /tasks/quickadd, a task can have. Zero means no limit.
+	maxTags, err = strconv.Atoi(envOr("MAX_TAGS", "0"))
+	if err != nil || maxTags < 0 {
+		log.Fatal("MAX_TAGS: must be a positive number")
+	}
+	// MAX_TASKS_WARN and MAX_TAGS_WARN are the soft limits below MAX_TASKS
+	// and MAX_TAGS from which creating a task warns of the limit, e.g. 90
+	// for MAX_TASKS=100. Zero does not warn.
+	taskSoftLimit, err = parseSoftLimit(envOr("MAX_TASKS_WARN", "0"), int(maxTasks))
+	if err != nil {
+		log.Fatal("MAX_TASKS_WARN: ", err)
+	}
+	tagSoftLimit, err = parseSoftLimit(envOr("MAX_TAGS_WARN", "0"), maxTags)
+	if err != nil {
+		log.Fatal("MAX_TAGS_WARN: ", err)
+	}
+	// STALE_READS=true answers GET /tasks with the last good response of
+	// the same request, with a Warning header, while the database is
+	// unreachable. Other requests fail with 503 either way.
+	var staleLists *staleCache
+	if ok, _ := strconv.ParseBool(os.Getenv("STALE_READS")); ok {
+		staleLists = newStaleCache()
+	}
+	// RESPONSE_CACHE caches the GET responses of routes in memory, e.g.
+	// "/tasks=5s:30s,/tasks/heatmap=1m:10m": the responses of /tasks are
+	// fresh for 5s and served stale while refreshed in the background for
+	// 30s more. Writes empty the cache. See responseCache.
+	cacheRules, err := parseCacheRules(os.Getenv("RESPONSE_CACHE"))
+	if err != nil {
+		log.Fatal("RESPONSE_CACHE: ", err)
+	}
+	// DEMO_MODE=true runs without Postgres: the tasks are kept in memory
+	// and lost on restart. The features querying the database directly,
+	// such as comments, templates or the admin routes, answer 501, and
+	// API_QUOTA and the email reminders are disabled.
+	demo, _ := strconv.ParseBool(os.Getenv("DEMO_MODE"))
+	// HTTPS_REDIRECT_PROXIES lists the addresses or CIDR ranges of the
+	// TLS-terminating proxies, e.g. "10.0.0.0/8". When set, the requests
+	// they forward with X-Forwarded-Proto: http are redirected to HTTPS,
+	// see httpsRedirect.
+	httpsProxies, err := parsePrefixes(os.Getenv("HTTPS_REDIRECT_PROXIES"))
+	if err != nil {
+		log.Fatal("HTTPS_REDIRECT_PROXIES: ", err)
+	}
+	// SLOW_QUERY_THRESHOLD is the duration from which queries are logged
+	// as slow and counted by GET /metrics.
+	slowQueryThreshold, err := time.ParseDuration(envOr("SLOW_QUERY_THRESHOLD", "3s"))
+	if err != nil || slowQueryThreshold <= 0 {
+		log.Fatal("SLOW_QUERY_THRESHOLD: must be a positive duration")
+	}
+	// INSERT_BATCH_SIZE is the most rows POST /admin/restore inserts per
+	// statement.
+	insertBatchSize, err = strconv.Atoi(envOr("INSERT_BATCH_SIZE", "1000"))
+	if err != nil || insertBatchSize <= 0 {
+		log.Fatal("INSERT_BATCH_SIZE: must be a positive number")
+	}
+	// MAX_IDS is the most ids a request may name, in ?ids= or in the body
+	// of the bulk endpoints. Longer lists are answered 400.
+	maxIDs, err = strconv.Atoi(envOr("MAX_IDS", "100"))
+	if err != nil || maxIDs <= 0 {
+		log.Fatal("MAX_IDS: must be a positive number")
+	}
+	// MAX_IMPORT_TASKS is the most tasks POST /tasks/import creates in a
+	// request. Larger exports are answered 400 and must be split.
+	maxImportTasks, err := strconv.Atoi(envOr("MAX_IMPORT_TASKS", "1000"))
+	if err != nil || maxImportTasks <= 0 {
+		log.Fatal("MAX_IMPORT_TASKS: must be a positive number")
+	}
+	// TASK_ID_SCHEME is how the API shows the ids of the tasks, comments
+	// and templates: serial, the default, shows the numbers of the
+	// database, which tell how many tasks there are and can be guessed.
+	// uuid shows them encrypted with TASK_ID_KEY as UUIDs, see formatID,
+	// and refuses numbers in URLs, query strings and JSON bodies. The
+	// database keeps its bigint keys either way, so the scheme can be
+	// changed without a migration, but the ids given out under uuid only
+	// work with the same scheme and key: changing either breaks the links
+	// and copies of the clients. Backups hold the ids as shown, and share
+	// tokens and activity cursors still carry the numbers.
+	idCipher, err = newIDCipher(envOr("TASK_ID_SCHEME", "serial"), os.Getenv("TASK_ID_KEY"))
+	if err != nil {
+		log.Fatal("TASK_ID_SCHEME: ", err)
+	}
+	// CORS_ALLOWED_ORIGINS lists the origins browsers may call the API
+	// from, e.g. "https://app.example.com,https://*.example.com", see
+	// originPattern. Empty allows none.
+	corsOrigins, err := parseOriginPatterns(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if err != nil {
+		log.Fatal("CORS_ALLOWED_ORIGINS: ", err)
+	}
+	// RANDOM_WEIGHTING is the default weighting of GET /tasks/random, see
+	// randomWeights.
+	randomWeighting := envOr("RANDOM_WEIGHTING", "mixed")
+	if randomWeights[randomWeighting] == nil {
+		log.Fatal("RANDOM_WEIGHTING: must be uniform, priority, urgency or mixed")
+	}
+	// METADATA_MAX_DEPTH is the deepest nesting of objects and arrays the
+	// metadata of a task can have, the metadata object itself counting as
+	// 1, and METADATA_MAX_BYTES the largest size of its JSON. Zero means
+	// no limit.
+	maxMetadataDepth, err = strconv.Atoi(envOr("METADATA_MAX_DEPTH", "8"))
+	if err != nil || maxMetadataDepth < 0 {
+		log.Fatal("METADATA_MAX_DEPTH: must be a positive number")
+	}
+	maxMetadataBytes, err = strconv.Atoi(envOr("METADATA_MAX_BYTES", "65536"))
+	if err != nil || maxMetadataBytes < 0 {
+		log.Fatal("METADATA_MAX_BYTES: must be a positive number")
+	}
+	// SHARE_SECRET is the key signing the share links of POST
+	// /tasks/:id/share. Without it a random key is made at startup, so the
+	// links stop working on restart and differ between replicas.
+	shares := &shareSigner{key: []byte(os.Getenv("SHARE_SECRET"))}
+	if len(shares.key) == 0 {
+		shares.key = make([]byte, 32)
+		if _, err := rand.Read(shares.key); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// FOCUS_LIMIT is the number of tasks of GET /tasks/focus.
+	focusLimit, err := strconv.Atoi(envOr("FOCUS_LIMIT", "3"))
+	if err != nil || focusLimit <= 0 {
+		log.Fatal("FOCUS_LIMIT: must be a positive number")
+	}
+
+	var db *sql.DB
+	if demo {
+		db = sql.OpenDB(noDatabase{})
+	} else {
+		connector, err := pq.NewConnector(databaseURL())
+		if err != nil {
+			log.Fatal(err)
+		}
+		db = sql.OpenDB(&sessionConnector{Connector: connector, statements: sessionTimeouts(dbTimeouts), ping: pingOnBorrow})
+	}
+	db.SetConnMaxIdleTime(connMaxIdleTime)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	bundb := bun.NewDB(db, pgdialect.New())
+	for _, hook := range queryHooks(appEnv == "development", slowQueryThreshold, redact, os.Stdout) {
+		bundb.AddQueryHook(hook)
+	}
+	slowQueries := newSlowQueryMetrics(slowQueryThreshold)
+	bundb.AddQueryHook(slowQueries)
+
+	if !demo {
+		err = migrate(context.Background(), bundb)
+		if err != nil {
+			bundb.Close()
+			return nil, nil, err
+		}
+	}
+
+	mime.AddExtensionType(".js", "application/javascript")
+
+	e := echo.New()
+	e.JSONSerializer = &jsonSerializer{pretty: jsonPretty, stringIDs: jsonStringIDs, timeFormat: jsonTimeFormat, namedLists: jsonNamedLists}
+	e.Binder = &strictBinder{strict: strictJSON}
+	// the timeout middleware replaces the response writer, so it must come
+	// first.
+	if timeout > 0 {
+		e.Use(requestTimeout(timeout))
+	}
+	e.Use(requestLogger(logSampleRate, redact))
+	requests := newRequestMetrics()
+	e.Use(requests.middleware)
+	if len(httpsProxies) > 0 {
+		e.Use(httpsRedirect(httpsProxies))
+	}
+	// preflight requests carry no API key, so CORS comes before the
+	// authentication.
+	if len(corsOrigins) > 0 {
+		e.Use(cors(corsOrigins))
+	}
+	if len(apiKeys) > 0 {
+		e.Use(apiKeyAuth(apiKeys, public))
+		if apiQuotaLimit > 0 && !demo {
+			e.Use(apiQuota(bundb, apiQuotaLimit, public))
+		}
+	}
+	if len(cacheRules) > 0 {
+		e.Use(newResponseCache(cacheRules).middleware)
+	}
+	if maxConcurrency > 0 {
+		e.Use(concurrencyLimit(maxConcurrency))
+	}
+	if demo {
+		e.Use(requireDatabase)
+	}
+
+	var store TaskStore = newBunStore(bundb, maxTasks, priorityLimits, duplicateTasks != "allow")
+	if demo {
+		store = newMemoryStore(maxTasks, priorityLimits, duplicateTasks != "allow")
+	}
+	if coalesce {
+		store = newCoalescingStore(store, coalesceTimeout)
+	}
+	if caldavURL != "" {
+		caldav := &caldavSync{
+			url:      caldavURL,
+			username: os.Getenv("CALDAV_USERNAME"),
+			password: os.Getenv("CALDAV_PASSWORD"),
+			client:   &http.Client{Timeout: 30 * time.Second},
+			store:    store,
+		}
+		go caldav.run(context.Background(), caldavInterval)
+	}
+	if demo {
+		// the reminders query the database for the due tasks.
+		reminders = nil
+	}
+	if reminders != nil {
+		reminders.db = bundb
+		go reminders.run(context.Background(), reminderInterval)
+	}
+	if cleanupRetention > 0 {
+		go cleanupCompleted(context.Background(), store, cleanupRetention, cleanupInterval, undoWindow)
+	}
+
+	// createTask validates and creates the task of a create request.
+	createTask := func(c echo.Context, task Task) error {
+		if err := validateTask(&task); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		warnings, err := taskWarnings(c, store, &task)
+		if err != nil {
+			return storeError(c, err)
+		}
+		err = store.Create(c.Request().Context(), &task)
+		if errors.Is(err, errTaskLimit) {
+			return c.JSON(http.StatusForbidden, msg(c, "task_limit", maxTasks))
+		}
+		var de *duplicateTaskError
+		if errors.As(err, &de) && duplicateTasks == "existing" {
+			return taskResponse(c, http.StatusOK, de.task)
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		task.Warnings = warnings
+		return taskResponse(c, http.StatusCreated, &task)
+	}
+	// upsertTask validates the task of an upsert request and creates it,
+	// or updates the task with its external_id.
+	upsertTask := func(c echo.Context, task Task) error {
+		if task.ExternalID == "" {
+			return c.String(http.StatusBadRequest, msg(c, "missing_param", "external_id"))
+		}
+		if err := validateTask(&task); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		warnings, err := taskWarnings(c, store, &task)
+		if err != nil {
+			return storeError(c, err)
+		}
+		created, err := store.Upsert(c.Request().Context(), &task)
+		if errors.Is(err, errTaskLimit) {
+			return c.JSON(http.StatusForbidden, msg(c, "task_limit", maxTasks))
+		}
+		var de *duplicateTaskError
+		if errors.As(err, &de) && duplicateTasks == "existing" {
+			return taskResponse(c, http.StatusOK, de.task)
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		task.Warnings = warnings
+		if created {
+			return taskResponse(c, http.StatusCreated, &task)
+		}
+		return taskResponse(c, http.StatusOK, &task)
+	}
+	// POST /tasks creates the task and answers 201 with its Location, or
+	// 200 with the existing task under DUPLICATE_TASKS=existing.
+	//
+	// POST /tasks?upsert=true creates the task, or updates the task with
+	// the external_id of the body, deleted or not, so that other systems
+	// can push their tasks without looking them up first. Fields missing
+	// from the body take the defaults, as for a new task. It answers 201
+	// when the task was created and 200 when it was updated.
+	e.POST("/tasks", func(c echo.Context) error {
+		task := defaults.newTask(time.Now())
+		if err := c.Bind(&task); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		sanitizeChecklist(task.Checklist)
+		if upsert, _ := strconv.ParseBool(c.QueryParam("upsert")); upsert {
+			return upsertTask(c, task)
+		}
+		return createTask(c, task)
+	})
+	// POST /parse-date previews the due date of a phrase such as "next
+	// friday" or "in 3 days", see parseDatePhrase, as a day in the ?tz=
+	// time zone. It answers 422 when the phrase names no day.
+	e.POST("/parse-date", func(c echo.Context) error {
+		var req struct {
+			Phrase string `json:"phrase"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		date, ok := parseDatePhrase(req.Phrase, time.Now().In(loc))
+		if !ok {
+			return c.String(http.StatusUnprocessableEntity, msg(c, "invalid_date", req.Phrase))
+		}
+		return c.JSON(http.StatusOK, map[string]time.Time{"date": date})
+	})
+	// POST /tasks/quickadd creates a task from {"text": "..."} written in
+	// the quick-add syntax, e.g. "Buy milk !high #groceries tomorrow". See
+	// quickAdd for the grammar. Dates are days in the ?tz= time zone. It
+	// answers like POST /tasks.
+	e.POST("/tasks/quickadd", func(c echo.Context) error {
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		now := time.Now()
+		task := defaults.newTask(now)
+		quick.parse(&task, req.Text, now.In(loc))
+		if task.Text == "" {
+			return c.String(http.StatusBadRequest, msg(c, "missing_param", "text"))
+		}
+		return createTask(c, task)
+	})
+
+	// POST /tasks/validate checks a task like POST /tasks without creating
+	// it and always answers 200 with a TaskValidation, so forms can show
+	// the errors and warnings as the user types.
+	e.POST("/tasks/validate", func(c echo.Context) error {
+		task := defaults.newTask(time.Now())
+		if err := c.Bind(&task); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		sanitizeChecklist(task.Checklist)
+		result := TaskValidation{Errors: []string{}, Warnings: []string{}}
+		for _, err := range taskErrors(&task) {
+			result.Errors = append(result.Errors, errorMessage(c, err))
+		}
+		if duplicateTasks != "allow" {
+			err := store.FindDuplicate(c.Request().Context(), task.Text, 0)
+			var de *duplicateTaskError
+			if errors.As(err, &de) && duplicateTasks == "reject" {
+				result.Errors = append(result.Errors, msg(c, "task_duplicate", de.task.ID))
+			} else if errors.As(err, &de) {
+				result.Warnings = append(result.Warnings, msg(c, "warning_existing", de.task.ID))
+			} else if err != nil {
+				return storeError(c, err)
+			}
+		}
+		warnings, err := taskWarnings(c, store, &task)
+		if err != nil {
+			return storeError(c, err)
+		}
+		result.Warnings = append(result.Warnings, warnings...)
+		result.Valid = len(result.Errors) == 0
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// withPreferences applies the saved Preferences to the filter of a list
+	// request, unless the tasks are requested by ids. There are none in
+	// demo mode.
+	withPreferences := func(c echo.Context, f *TaskFilter) error {
+		if f.IDs != nil || demo {
+			return nil
+		}
+		prefs, err := loadPreferences(c.Request().Context(), bundb)
+		if err != nil {
+			return err
+		}
+		prefs.apply(c, f)
+		return nil
+	}
+
+	e.GET("/tasks", func(c echo.Context) error {
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		if err := withPreferences(c, &f); err != nil {
+			if ok, err := staleLists.serve(c, err); ok {
+				return err
+			}
+			return storeError(c, err)
+		}
+		// ?format=ndjson streams the tasks as one JSON object per line.
+		format := c.QueryParam("format")
+		switch format {
+		case "", "json":
+			format = "json"
+		case "ndjson":
+		default:
+			return c.String(http.StatusBadRequest, msg(c, "invalid_param", "format"))
+		}
+		// ?download=true exports the tasks as a file. The export honors
+		// the filters, search and sort of the list like any other request,
+		// e.g. ?completed=false&q=report&download=true.
+		download, _ := strconv.ParseBool(c.QueryParam("download"))
+		if download {
+			c.Response().Header().Set(echo.HeaderContentDisposition,
+				fmt.Sprintf(`attachment; filename="%s-tasks-%s.%s"`, name, time.Now().UTC().Format("20060102T150405Z"), format))
+		}
+		if format == "ndjson" {
+			return streamNDJSON(c, store, f, previewLength)
+		}
+		// exports and lists by ids are never paged by default.
+		if f.Limit == 0 && f.IDs == nil && !download && !c.QueryParams().Has("limit") {
+			f.Limit = defaultPageSize
+		}
+		envelope, _ := strconv.ParseBool(c.QueryParam("envelope"))
+		if !envelope {
+			envelope, _ = strconv.ParseBool(c.Request().Header.Get("X-Envelope"))
+		}
+		tasks, err := store.List(c.Request().Context(), f)
+		var total int
+		if err == nil && envelope {
+			total, err = store.Count(c.Request().Context(), f)
+		}
+		if ok, err := staleLists.serve(c, err); ok {
+			return err
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		if previewLength > 0 {
+			for i := range tasks {
+				tasks[i].Preview = preview(tasks[i].Text, previewLength)
+			}
+		}
+		if f.IDs != nil {
+			// tasks fetched by ids are returned in the order requested.
+			pos := make(map[int64]int, len(f.IDs))
+			for i, id := range f.IDs {
+				pos[id] = i
+			}
+			sort.Slice(tasks, func(i, j int) bool {
+				return pos[tasks[i].ID] < pos[tasks[j].ID]
+			})
+		}
+		if envelope {
+			page := Page{
+				Data: tasks,
+				Pagination: Pagination{
+					Total:  total,
+					Limit:  f.Limit,
+					Offset: f.Offset,
+				},
+			}
+			staleLists.put(c, page)
+			return c.JSON(http.StatusOK, page)
+		}
+		staleLists.put(c, tasks)
+		return c.JSON(http.StatusOK, tasks)
+	})
+
+	// GET /tasks/export?format=zip exports the tasks as a zip archive of
+	// one Markdown file per task, for notes apps such as Obsidian. It takes
+	// the filters, search and sort of GET /tasks.
+	e.GET("/tasks/export", func(c echo.Context) error {
+		if format := c.QueryParam("format"); format != "" && format != "zip" {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_param", "format"))
+		}
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		if err := withPreferences(c, &f); err != nil {
+			return storeError(c, err)
+		}
+		return streamZip(c, store, f)
+	})
+
+	// GET /tasks/grouped?by=<field> returns an object mapping each group to
+	// its tasks in list order. Tasks without a due date or a color are put
+	// in the "none" group.
+	e.GET("/tasks/grouped", func(c echo.Context) error {
+		by := c.QueryParam("by")
+		key, ok := taskGroupKeys[by]
+		if !ok {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_group", by))
+		}
+		tasks, err := store.List(c.Request().Context(), TaskFilter{})
+		if err != nil {
+			return storeError(c, err)
+		}
+		groups := map[string][]Task{}
+		for _, task := range tasks {
+			k := key(&task)
+			groups[k] = append(groups[k], task)
+		}
+		return c.JSON(http.StatusOK, groups)
+	})
+
+	e.POST("/tasks/:id", func(c echo.Context) error {
+		var req TaskUpdate
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		task, err := store.Update(c.Request().Context(), id, func(task *Task) error {
+			req.apply(task)
+			if err := validateTask(task); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return taskResponse(c, http.StatusOK, task)
+	})
+
+	// PATCH /tasks/:id/checklist replaces the checklist of the task with
+	// the array of {"text", "done"} items of the body. Send [] to clear it.
+	e.PATCH("/tasks/:id/checklist", func(c echo.Context) error {
+		var items []ChecklistItem
+		if err := c.Bind(&items); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		sanitizeChecklist(items)
+		task, err := store.Update(c.Request().Context(), id, func(task *Task) error {
+			task.Checklist = items
+			if err := validateTask(task); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return taskResponse(c, http.StatusOK, task)
+	})
+
+	e.POST("/tasks/:id/reorder", reorderHandler(bundb))
+
+	// POST /tasks/:id/snooze postpones the due date of the task by the
+	// duration of the body or to its preset, see snoozeUntil. The presets
+	// are days in the ?tz= time zone.
+	e.POST("/tasks/:id/snooze", func(c echo.Context) error {
+		var req struct {
+			Duration string `json:"duration"`
+			Preset   string `json:"preset"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		task, err := store.Update(c.Request().Context(), id, func(task *Task) error {
+			due, err := snoozeUntil(task.DueDate, time.Now().In(loc), req.Duration, req.Preset)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			task.DueDate = &due
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return taskResponse(c, http.StatusOK, task)
+	})
+
+	// POST /tasks/schedule with {"ids": [...], "due": "next monday"} sets
+	// the due date of the tasks to the day named by due, see
+	// parseDatePhrase, in the ?tz= time zone, in one transaction.
+	e.POST("/tasks/schedule", func(c echo.Context) error {
+		var req struct {
+			IDs []int64 `json:"ids"`
+			Due string  `json:"due"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if len(req.IDs) == 0 || len(req.IDs) > maxIDs {
+			return c.String(http.StatusBadRequest, msg(c, "ids_count", maxIDs))
+		}
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		due, ok := parseDatePhrase(req.Due, time.Now().In(loc))
+		if !ok {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_date", req.Due))
+		}
+		tasks := []Task{}
+		err = store.Atomic(c.Request().Context(), func(ctx context.Context, store TaskStore) error {
+			tasks = tasks[:0]
+			for _, id := range req.IDs {
+				task, err := store.Update(ctx, id, func(task *Task) error {
+					task.DueDate = &due
+					if err := validateTask(task); err != nil {
+						return echo.NewHTTPError(http.StatusBadRequest, err)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				tasks = append(tasks, *task)
+			}
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, tasks)
+	})
+
+	e.POST("/tasks/toggle", func(c echo.Context) error {
+		var req struct {
+			IDs []int64 `json:"ids"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if len(req.IDs) == 0 || len(req.IDs) > maxIDs {
+			return c.String(http.StatusBadRequest, msg(c, "ids_count", maxIDs))
+		}
+		tasks := []Task{}
+		err := withTx(c.Request().Context(), bundb, func(ctx context.Context, tx bun.Tx) error {
+			tasks = tasks[:0]
+			now := time.Now()
+			for ids := range slices.Chunk(req.IDs, idChunkSize) {
+				// tasks being completed must not have incomplete blockers.
+				blocked, err := tx.NewSelect().Model((*TaskDependency)(nil)).
+					Join(`JOIN "Task" AS t ON t.id = d.task_id`).
+					Join(`JOIN "Task" AS b ON b.id = d.blocker_id`).
+					Where("d.task_id IN (?)", bun.In(ids)).
+					Where("NOT t.completed").
+					Where("NOT b.completed").
+					Where("b.deleted_at IS NULL").
+					Exists(ctx)
+				if err != nil {
+					return err
+				}
+				if blocked {
+					return errTaskBlocked
+				}
+				var toggled []Task
+				_, err = tx.NewUpdate().Model(&toggled).
+					Set("completed = NOT completed").
+					Set("completed_at = CASE WHEN completed THEN NULL ELSE ?::timestamptz END", now).
+					Set("updated_at = ?", now).
+					Where("id IN (?)", bun.In(ids)).
+					Returning("*").
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+				tasks = append(tasks, toggled...)
+			}
+			// reopened tasks must fit in the limits of their priorities.
+			// They are already counted, so the limit is exceeded when the
+			// open tasks but one reach it.
+			reopened := map[int]bool{}
+			for _, task := range tasks {
+				if !task.Completed {
+					reopened[task.Priority] = true
+				}
+			}
+			for _, priority := range slices.Sorted(maps.Keys(reopened)) {
+				count := countOpen(ctx, tx, priority, 0)
+				err := priorityLimits.check(priority, func() (int, error) {
+					n, err := count()
+					return n - 1, err
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			sortByRank(tasks)
+			err = loadRelations(c.Request().Context(), bundb, tasks)
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, tasks)
+	})
+
+	// POST /tasks/bulk creates, POST /tasks/bulk/update updates and
+	// POST /tasks/bulk/delete deletes several tasks at once. They are all
+	// or nothing unless ?partial=true is given, see runBulk.
+	e.POST("/tasks/bulk", func(c echo.Context) error {
+		var req []json.RawMessage
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		return runBulk(c, store, maxTasks, len(req), func(ctx context.Context, store TaskStore, i int) (*Task, error) {
+			task := defaults.newTask(time.Now())
+			if err := json.Unmarshal(req[i], &task); err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			if strictJSON {
+				if err := checkFields(req[i], &task); err != nil {
+					return nil, err
+				}
+			}
+			if err := validateTask(&task); err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			if err := store.Create(ctx, &task); err != nil {
+				return nil, err
+			}
+			return &task, nil
+		})
+	})
+
+	e.POST("/tasks/bulk/update", func(c echo.Context) error {
+		var req []struct {
+			ID int64 `json:"id"`
+			TaskUpdate
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		return runBulk(c, store, maxTasks, len(req), func(ctx context.Context, store TaskStore, i int) (*Task, error) {
+			return store.Update(ctx, req[i].ID, func(task *Task) error {
+				req[i].apply(task)
+				if err := validateTask(task); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, err)
+				}
+				return nil
+			})
+		})
+	})
+
+	e.POST("/tasks/bulk/delete", func(c echo.Context) error {
+		var req struct {
+			IDs []int64 `json:"ids"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		now := time.Now()
+		return runBulk(c, store, maxTasks, len(req.IDs), func(ctx context.Context, store TaskStore, i int) (*Task, error) {
+			return store.Delete(ctx, req.IDs[i], now)
+		})
+	})
+
+	// POST /tasks/import?source=todoist creates the tasks of an export of
+	// another app in one transaction, MAX_IMPORT_TASKS at most. See
+	// importers for the sources.
+	importTasks := func(c echo.Context, source string) error {
+		importer, ok := importers[source]
+		if !ok {
+			return c.String(http.StatusBadRequest, msg(c, "unknown_source", source))
+		}
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		tasks, skipped, err := importer(b)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if len(tasks) > maxImportTasks {
+			return c.String(http.StatusBadRequest, msg(c, "import_size", len(tasks), maxImportTasks))
+		}
+		result := ImportResult{Imported: []Task{}, Skipped: skipped}
+		err = store.Atomic(c.Request().Context(), func(ctx context.Context, store TaskStore) error {
+			result.Imported = result.Imported[:0]
+			for _, task := range tasks {
+				if err := validateTask(&task); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, err)
+				}
+				if err := store.Create(ctx, &task); err != nil {
+					return err
+				}
+				result.Imported = append(result.Imported, task)
+			}
+			return nil
+		})
+		if errors.Is(err, errTaskLimit) {
+			return c.JSON(http.StatusForbidden, msg(c, "task_limit", maxTasks))
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+	e.POST("/tasks/import", func(c echo.Context) error {
+		return importTasks(c, c.QueryParam("source"))
+	})
+	// POST /tasks/import-text imports a plain text body, one task per line,
+	// like ?source=text.
+	e.POST("/tasks/import-text", func(c echo.Context) error {
+		return importTasks(c, "text")
+	})
+
+	// POST /tasks/:id/blockers with {"blocker_id": n} makes the task blocked
+	// by the task n, and DELETE /tasks/:id/blockers/:blocker removes it.
+	// Both return the updated task.
+	e.POST("/tasks/:id/blockers", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		var req struct {
+			BlockerID int64 `json:"blocker_id"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if req.BlockerID == id {
+			return c.String(http.StatusBadRequest, msg(c, "dependency_self"))
+		}
+		err = withTx(c.Request().Context(), bundb, func(ctx context.Context, tx bun.Tx) error {
+			// serialize dependency changes so that two concurrent requests
+			// cannot close a cycle together.
+			if _, err := tx.ExecContext(ctx, `LOCK TABLE "TaskDependency" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+			count, err := tx.NewSelect().Model((*Task)(nil)).Where("id IN (?)", bun.In([]int64{id, req.BlockerID})).Count(ctx)
+			if err != nil {
+				return err
+			}
+			if count != 2 {
+				return errTaskNotFound
+			}
+			cycle, err := dependsOn(ctx, tx, req.BlockerID, id)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return echo.NewHTTPError(http.StatusConflict, newError("dependency_cycle"))
+			}
+			_, err = tx.NewInsert().Model(&TaskDependency{TaskID: id, BlockerID: req.BlockerID}).On("CONFLICT DO NOTHING").Exec(ctx)
+			return err
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		task, err := store.Get(c.Request().Context(), id)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, task)
+	})
+
+	// GET /activity?limit=N returns the latest events across all tasks,
+	// newest first, 50 by default. Pass the returned next as ?cursor= to
+	// get the following ones. See activitySQL for what is known.
+	e.GET("/activity", func(c echo.Context) error {
+		limit := 50
+		if s := c.QueryParam("limit"); s != "" {
+			limit, err = strconv.Atoi(s)
+			if err != nil || limit <= 0 || limit > maxIDs {
+				return c.String(http.StatusBadRequest, msg(c, "invalid_param", "limit"))
+			}
+		}
+		var cursor *activityCursor
+		if s := c.QueryParam("cursor"); s != "" {
+			cursor, err = parseActivityCursor(s)
+			if err != nil {
+				return c.String(http.StatusBadRequest, errorMessage(c, err))
+			}
+		}
+		activity, err := listActivity(c.Request().Context(), bundb, cursor, limit)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, activity)
+	})
 
-	bundb := bun.NewDB(db, pgdialect.New())
-	bundb.AddQueryHook(
-		bundebug.NewQueryHook(
-			bundebug.WithVerbose(true),
-			bundebug.FromEnv("BUNDEBUG"),
-		),
-	)
-	bundb.AddQueryHook(
-		bunslog.NewQueryHook(
-			bunslog.WithQueryLogLevel(slog.LevelDebug),
-			bunslog.WithSlowQueryLogLevel(slog.LevelWarn),
-			bunslog.WithErrorQueryLogLevel(slog.LevelError),
-			bunslog.WithSlowQueryThreshold(3*time.Second),
-		),
-	)
-	defer bundb.Close()
-
-	_, err = bundb.NewCreateTable().Model((*Task)(nil)).IfNotExists().Exec(context.Background())
-	if err != nil {
-		log.Println(err)
-		return
+	// GET /tags returns the distinct tags with the number of tasks having
+	// each, by name or, with ?sort=count, most used first.
+	e.GET("/tags", func(c echo.Context) error {
+		sort := c.QueryParam("sort")
+		order, ok := tagOrders[cmp.Or(sort, "name")]
+		if !ok {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_sort", sort))
+		}
+		tags, err := listTags(c.Request().Context(), bundb, order)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, tags)
+	})
+
+	// POST /tags/:tag/assign and POST /tags/:tag/unassign with {"ids":
+	// [...]} add the tag to, or remove it from, the "tags" array of the
+	// metadata of the tasks in one transaction. Tasks already having, or
+	// not having, the tag are left as they are. They return the tasks.
+	tagTasks := func(change func(t *Task, tag string) bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tag := strings.TrimSpace(c.Param("tag"))
+			if tag == "" {
+				return c.String(http.StatusBadRequest, msg(c, "missing_param", "tag"))
+			}
+			var req struct {
+				IDs []int64 `json:"ids"`
+			}
+			if err := c.Bind(&req); err != nil {
+				c.Logger().Error("Bind: ", err)
+				return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+			}
+			if len(req.IDs) == 0 || len(req.IDs) > maxIDs {
+				return c.String(http.StatusBadRequest, msg(c, "ids_count", maxIDs))
+			}
+			tasks := []Task{}
+			err := store.Atomic(c.Request().Context(), func(ctx context.Context, store TaskStore) error {
+				tasks = tasks[:0]
+				for _, id := range req.IDs {
+					task, err := store.Get(ctx, id)
+					if err != nil {
+						return err
+					}
+					if change(task, tag) {
+						task, err = store.Update(ctx, id, func(task *Task) error {
+							change(task, tag)
+							if err := validateTask(task); err != nil {
+								return echo.NewHTTPError(http.StatusBadRequest, err)
+							}
+							return nil
+						})
+						if err != nil {
+							return err
+						}
+					}
+					tasks = append(tasks, *task)
+				}
+				return nil
+			})
+			if err != nil {
+				return storeError(c, err)
+			}
+			return c.JSON(http.StatusOK, tasks)
+		}
 	}
+	e.POST("/tags/:tag/assign", tagTasks(assignTag))
+	e.POST("/tags/:tag/unassign", tagTasks(unassignTag))
 
-	mime.AddExtensionType(".js", "application/javascript")
+	// POST /tasks/:id/merge with {"source_id": n} merges the task n into
+	// the task and deletes it, see mergeTasks. It returns the merged task.
+	e.POST("/tasks/:id/merge", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		var req struct {
+			SourceID int64 `json:"source_id"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if req.SourceID == id {
+			return c.String(http.StatusBadRequest, msg(c, "merge_self"))
+		}
+		if err := mergeTasks(c.Request().Context(), bundb, id, req.SourceID); err != nil {
+			return storeError(c, err)
+		}
+		task, err := store.Get(c.Request().Context(), id)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, task)
+	})
 
-	e := echo.New()
+	e.DELETE("/tasks/:id/blockers/:blocker", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		blocker, err := parseID(c.Param("blocker"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		result, err := bundb.NewDelete().Model((*TaskDependency)(nil)).
+			Where("task_id = ?", id).
+			Where("blocker_id = ?", blocker).
+			Exec(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		if num, err := result.RowsAffected(); err != nil || num == 0 {
+			return c.JSON(http.StatusNotFound, msg(c, "dependency_not_found"))
+		}
+		task, err := store.Get(c.Request().Context(), id)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, task)
+	})
 
-	e.POST("/tasks", func(c echo.Context) error {
-		var task Task
-		if err := c.Bind(&task); err != nil {
+	// POST /tasks/:id/comments with {"author": "...", "body": "..."} adds a
+	// comment to the task and GET /tasks/:id/comments lists them, oldest
+	// first.
+	e.POST("/tasks/:id/comments", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		var comment Comment
+		if err := c.Bind(&comment); err != nil {
 			c.Logger().Error("Bind: ", err)
 			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
 		}
-		_, err := bundb.NewInsert().Model(&task).Exec(context.Background())
+		comment.ID, comment.TaskID, comment.CreatedAt = 0, id, time.Now()
+		if err := validateComment(&comment); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		err = withTx(c.Request().Context(), bundb, func(ctx context.Context, tx bun.Tx) error {
+			// locks the task so that it cannot be deleted meanwhile.
+			err := tx.NewSelect().Model((*Task)(nil)).Column("id").Where("id = ?", id).For("SHARE").Scan(ctx, new(int64))
+			if errors.Is(err, sql.ErrNoRows) {
+				return errTaskNotFound
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.NewInsert().Model(&comment).Exec(ctx)
+			return err
+		})
 		if err != nil {
-			e.Logger.Error(err)
-			return c.JSON(http.StatusInternalServerError, err.Error())
+			return storeError(c, err)
 		}
-		return c.JSON(http.StatusOK, task)
+		return c.JSON(http.StatusCreated, comment)
 	})
 
-	e.GET("/tasks", func(c echo.Context) error {
-		tasks := []Task{}
-		err := bundb.NewSelect().Model((*Task)(nil)).Order("id").Scan(context.Background(), &tasks)
+	e.GET("/tasks/:id/comments", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
 		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if _, err := store.Get(c.Request().Context(), id); err != nil {
+			return storeError(c, err)
+		}
+		comments := []Comment{}
+		err = bundb.NewSelect().Model(&comments).Where("task_id = ?", id).Order("created_at", "id").Scan(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, comments)
+	})
+
+	// GET /tasks/:id/next and /prev return the task after or before the
+	// task in the list as GET /tasks would return it with the same filter
+	// and sort parameters.
+	adjacent := func(column string) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := parseID(c.Param("id"))
+			if err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			f, err := parseTaskFilter(c)
+			if err != nil {
+				return c.String(http.StatusBadRequest, errorMessage(c, err))
+			}
+			if err := withPreferences(c, &f); err != nil {
+				return storeError(c, err)
+			}
+			order := taskSorts[f.Sort]
+			window := applyTaskFilter(bundb.NewSelect().Model((*Task)(nil)).
+				Column("id").
+				ColumnExpr("lag(id) OVER (ORDER BY "+order+") AS prev_id").
+				ColumnExpr("lead(id) OVER (ORDER BY "+order+") AS next_id"), f)
+			var other sql.NullInt64
+			err = bundb.NewSelect().
+				ColumnExpr("?", bun.Ident(column)).
+				TableExpr("(?) AS w", window).
+				Where("id = ?", id).
+				Scan(c.Request().Context(), &other)
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, msg(c, "task_not_found"))
+			}
+			if err != nil {
+				return storeError(c, err)
+			}
+			if !other.Valid {
+				return c.JSON(http.StatusNotFound, msg(c, "no_adjacent_task"))
+			}
+			task, err := store.Get(c.Request().Context(), other.Int64)
+			if err != nil {
+				return storeError(c, err)
+			}
+			return c.JSON(http.StatusOK, task)
+		}
+	}
+	e.GET("/tasks/:id/next", adjacent("next_id"))
+	e.GET("/tasks/:id/prev", adjacent("prev_id"))
+
+	// POST /tasks/:id/star stars the task and DELETE unstars it. Both are
+	// idempotent and return the updated task.
+	star := func(starred bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := parseID(c.Param("id"))
+			if err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			task, err := store.Update(c.Request().Context(), id, func(task *Task) error {
+				task.Starred = starred
+				return nil
+			})
+			if err != nil {
+				return storeError(c, err)
+			}
+			return taskResponse(c, http.StatusOK, task)
+		}
+	}
+	e.POST("/tasks/:id/star", star(true))
+	e.DELETE("/tasks/:id/star", star(false))
+
+	e.DELETE("/tasks/:id", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		task, err := store.Delete(c.Request().Context(), id, time.Now())
+		if errors.Is(err, errTaskNotFound) {
+			return c.JSON(http.StatusNotFound, msg(c, "task_not_found"))
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		if err := store.Purge(c.Request().Context(), time.Now().Add(-undoWindow)); err != nil {
 			e.Logger.Error(err)
-			return c.JSON(http.StatusInternalServerError, err.Error())
 		}
-		return c.JSON(http.StatusOK, tasks)
+		switch deleteResponse {
+		case "id":
+			return c.JSON(http.StatusOK, id)
+		case "task":
+			return c.JSON(http.StatusOK, task)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	// GET /tasks/focus returns the FOCUS_LIMIT tasks to do now, or ?limit=
+	// of them: the pending tasks not blocked by another pending task, in
+	// the order of ?sort=focus. It responds 204 No Content when there are
+	// none.
+	e.GET("/tasks/focus", func(c echo.Context) error {
+		limit := focusLimit
+		if s := c.QueryParam("limit"); s != "" {
+			limit, err = strconv.Atoi(s)
+			if err != nil || limit <= 0 || limit > maxIDs {
+				return c.String(http.StatusBadRequest, msg(c, "invalid_param", "limit"))
+			}
+		}
+		tasks, err := listUnblocked(c.Request().Context(), store, TaskFilter{Sort: "focus"})
+		if err != nil {
+			return storeError(c, err)
+		}
+		if len(tasks) == 0 {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, tasks[:min(limit, len(tasks))])
 	})
 
-	e.POST("/tasks/:id", func(c echo.Context) error {
-		var task Task
-		if err := c.Bind(&task); err != nil {
+	// statsError is storeError for the aggregation endpoints, which answer
+	// 503 when their queries time out.
+	statsError := func(c echo.Context, err error) error {
+		if timedOut(err) {
+			c.Logger().Error(c.Path(), ": ", err)
+			return c.JSON(http.StatusServiceUnavailable, msg(c, "stats_timeout"))
+		}
+		return storeError(c, err)
+	}
+	var statsMiddleware []echo.MiddlewareFunc
+	if statsTimeout > 0 {
+		statsMiddleware = append(statsMiddleware, contextTimeout(statsTimeout))
+	}
+
+	// GET /tasks/estimate sums the estimates of the tasks matching the
+	// filter parameters of GET /tasks, e.g. ?completed=false for the
+	// remaining effort. ?by= also sums them per group as in GET
+	// /tasks/grouped.
+	e.GET("/tasks/estimate", func(c echo.Context) error {
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		var key func(*Task) string
+		if by := c.QueryParam("by"); by != "" {
+			var ok bool
+			if key, ok = taskGroupKeys[by]; !ok {
+				return c.String(http.StatusBadRequest, msg(c, "invalid_group", by))
+			}
+		}
+		tasks, err := store.List(c.Request().Context(), f)
+		if err != nil {
+			return statsError(c, err)
+		}
+		var report EstimateReport
+		if key != nil {
+			report.Groups = map[string]*Estimate{}
+		}
+		for _, task := range tasks {
+			report.add(&task)
+			if key != nil {
+				k := key(&task)
+				if report.Groups[k] == nil {
+					report.Groups[k] = &Estimate{}
+				}
+				report.Groups[k].add(&task)
+			}
+		}
+		return c.JSON(http.StatusOK, report)
+	}, statsMiddleware...)
+
+	// GET /tasks/today returns the tasks due today in the ?tz= time zone
+	// as an Agenda, by hour. It takes the filters of GET /tasks.
+	e.GET("/tasks/today", func(c echo.Context) error {
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		today := startOfDay(time.Now().In(loc))
+		tomorrow := today.AddDate(0, 0, 1)
+		f.DueFrom, f.DueBefore, f.Limit, f.Offset = &today, &tomorrow, 0, 0
+		tasks, err := store.List(c.Request().Context(), f)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, newAgenda(today, tasks))
+	})
+	// POST /tasks/:id/share makes a link showing the task read-only to
+	// anyone having it, without an API key, at GET /shared/:token. With
+	// {"expires_in": "72h"} the link expires. DELETE /tasks/:id/share
+	// revokes all the links of the task made until then; deleting the task
+	// or changing SHARE_SECRET revokes them too.
+	e.POST("/tasks/:id/share", func(c echo.Context) error {
+		var req struct {
+			ExpiresIn string `json:"expires_in"`
+		}
+		if err := c.Bind(&req); err != nil {
 			c.Logger().Error("Bind: ", err)
 			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
 		}
-		completed := task.Completed
-		err := bundb.NewSelect().Model((*Task)(nil)).Where("id = ?", c.Param("id")).Scan(context.Background(), &task)
+		id, err := parseID(c.Param("id"))
 		if err != nil {
-			e.Logger.Error(err)
-			return c.JSON(http.StatusInternalServerError, err.Error())
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		var link ShareLink
+		claims := shareClaims{TaskID: id}
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				return c.String(http.StatusBadRequest, msg(c, "invalid_param", "expires_in"))
+			}
+			expires := time.Now().Add(d).Truncate(time.Second)
+			claims.ExpiresAt, link.ExpiresAt = expires.Unix(), &expires
 		}
-		task.Completed = completed
-		result, err := bundb.NewUpdate().Model(&task).Where("id = ?", c.Param("id")).Exec(context.Background())
+		task, err := store.Get(c.Request().Context(), id)
 		if err != nil {
-			e.Logger.Error(err)
-			return c.JSON(http.StatusInternalServerError, err.Error())
+			return storeError(c, err)
 		}
-		if num, err := result.RowsAffected(); err != nil || num == 0 {
-			return c.JSON(http.StatusInternalServerError, "No records updated")
+		claims.Version = task.ShareVersion
+		link.Token = shares.sign(claims)
+		link.URL = "/shared/" + link.Token
+		return c.JSON(http.StatusOK, link)
+	})
+	e.DELETE("/tasks/:id/share", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		_, err = store.Update(c.Request().Context(), id, func(task *Task) error {
+			task.ShareVersion++
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	// GET /shared/:token returns the task of a share link. Invalid,
+	// expired and revoked links are answered 404 like missing tasks.
+	e.GET("/shared/:token", func(c echo.Context) error {
+		claims, ok := shares.verify(c.Param("token"), time.Now())
+		if !ok {
+			return storeError(c, errTaskNotFound)
+		}
+		task, err := store.Get(c.Request().Context(), claims.TaskID)
+		if err == nil && task.ShareVersion != claims.Version {
+			err = errTaskNotFound
+		}
+		if err != nil {
+			return storeError(c, err)
 		}
+		c.Response().Header().Set("Cache-Control", "private, no-cache")
 		return c.JSON(http.StatusOK, task)
 	})
 
-	e.DELETE("/tasks/:id", func(c echo.Context) error {
-		id, err := strconv.Atoi(c.Param("id"))
+	// GET /tasks/metrics returns the counts of tasks in the OpenMetrics
+	// text format, see writeTaskMetrics, for dashboards about the data
+	// rather than the traffic of GET /metrics.
+	e.GET("/tasks/metrics", func(c echo.Context) error {
+		var buf bytes.Buffer
+		if err := writeTaskMetrics(c.Request().Context(), &buf, store, time.Now()); err != nil {
+			return storeError(c, err)
+		}
+		return c.Blob(http.StatusOK, "application/openmetrics-text; version=1.0.0; charset=utf-8", buf.Bytes())
+	})
+	// GET /tasks/random picks a pending task not blocked by another pending
+	// task at random, weighted by RANDOM_WEIGHTING or ?weight=, see
+	// randomWeights. It takes the filters of GET /tasks and answers 404
+	// when no task matches.
+	e.GET("/tasks/random", func(c echo.Context) error {
+		weighting := randomWeighting
+		if s := c.QueryParam("weight"); s != "" {
+			weighting = s
+		}
+		weight, ok := randomWeights[weighting]
+		if !ok {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_param", "weight"))
+		}
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		tasks, err := listUnblocked(c.Request().Context(), store, f)
+		if err != nil {
+			return storeError(c, err)
+		}
+		task := pickRandom(tasks, weight, time.Now())
+		if task == nil {
+			return storeError(c, errTaskNotFound)
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.JSON(http.StatusOK, task)
+	})
+	// GET /tasks/plan?minutes=<budget> suggests the pending tasks to do in
+	// a work session of that many minutes, at most a day: the subset of
+	// the tasks with an estimate and not blocked by another pending task
+	// with the most priority fitting in the budget, see planTasks, in the
+	// order of ?sort=focus. It takes the filters of GET /tasks.
+	e.GET("/tasks/plan", func(c echo.Context) error {
+		minutes, err := strconv.Atoi(c.QueryParam("minutes"))
+		if err != nil || minutes <= 0 || minutes > maxPlanMinutes {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_param", "minutes"))
+		}
+		f, err := parseTaskFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		f.Sort = "focus"
+		tasks, err := listUnblocked(c.Request().Context(), store, f)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, planTasks(tasks, minutes))
+	})
+
+	// GET /tasks/streak?tz=<IANA time zone> returns the completion
+	// streaks, with days starting at midnight in tz, UTC by default.
+	e.GET("/tasks/streak", func(c echo.Context) error {
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		streak, err := store.Streak(c.Request().Context(), loc)
+		if err != nil {
+			return statsError(c, err)
+		}
+		return c.JSON(http.StatusOK, streak)
+	}, statsMiddleware...)
+
+	// GET /tasks/heatmap?year=<year>&tz=<IANA time zone> returns the
+	// number of tasks completed per day of the year, the current one by
+	// default, keyed by date. Days without completions are left out.
+	e.GET("/tasks/heatmap", func(c echo.Context) error {
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		year := time.Now().In(loc).Year()
+		if s := c.QueryParam("year"); s != "" {
+			year, err = strconv.Atoi(s)
+			if err != nil || year < 1 || year > 9999 {
+				return c.String(http.StatusBadRequest, msg(c, "invalid_param", "year"))
+			}
+		}
+		from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		heatmap, err := store.Heatmap(c.Request().Context(), from, from.AddDate(1, 0, 0), loc)
+		if err != nil {
+			return statsError(c, err)
+		}
+		return c.JSON(http.StatusOK, heatmap)
+	}, statsMiddleware...)
+
+	// GET /tasks/changes?since=<RFC 3339 time> returns the tasks created or
+	// updated and the ids of the tasks deleted after since, for clients
+	// syncing by polling. Pass the returned now as since of the next poll.
+	// Deletions are only known for UNDO_WINDOW, so clients polling less
+	// often should fetch the whole list instead.
+	e.GET("/tasks/changes", func(c echo.Context) error {
+		since, err := time.Parse(time.RFC3339Nano, c.QueryParam("since"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, msg(c, "invalid_param", "since"))
+		}
+		// taken before reading so that changes made meanwhile are
+		// returned again rather than missed.
+		now := time.Now()
+		tasks, deleted, err := store.Changes(c.Request().Context(), since)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, Changes{Tasks: tasks, Deleted: deleted, Now: now})
+	})
+
+	// POST /tasks/sync applies the changes a client made while offline,
+	// {"since": <RFC 3339 time>, "changes": [SyncChange, ...]}, in order,
+	// each on its own, and returns a SyncResult per change along with the
+	// changes since since, as GET /tasks/changes does. See applySync for
+	// how conflicts are resolved. The times of the changes are compared
+	// with the server clock, so clients should correct them for skew.
+	e.POST("/tasks/sync", func(c echo.Context) error {
+		var req struct {
+			Since   time.Time    `json:"since"`
+			Changes []SyncChange `json:"changes"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if len(req.Changes) > maxIDs {
+			return c.String(http.StatusBadRequest, msg(c, "bulk_size", maxIDs))
+		}
+		newTask := func() Task { return defaults.newTask(time.Now()) }
+		res := SyncResponse{Results: make([]SyncResult, len(req.Changes))}
+		for i := range req.Changes {
+			ch := &req.Changes[i]
+			r := &res.Results[i]
+			r.Index, r.ClientID = i, ch.ClientID
+			var err error
+			r.Result, r.Task, err = applySync(c.Request().Context(), store, newTask, ch)
+			if err != nil {
+				r.Status, r.Error = bulkStatus(c, err, maxTasks)
+			}
+		}
+		res.Changes.Now = time.Now()
+		var err error
+		res.Changes.Tasks, res.Changes.Deleted, err = store.Changes(c.Request().Context(), req.Since)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+
+	// POST /tasks/undo restores the tasks removed by the latest deletion
+	// within UNDO_WINDOW. Tasks deleted together are restored together.
+	// There are no users, so the latest deletion is the server's.
+	e.POST("/tasks/undo", func(c echo.Context) error {
+		tasks, err := store.Restore(c.Request().Context(), time.Now().Add(-undoWindow))
+		if errors.Is(err, errTaskLimit) {
+			return c.JSON(http.StatusForbidden, msg(c, "task_limit", maxTasks))
+		}
+		if err != nil {
+			return storeError(c, err)
+		}
+		if len(tasks) == 0 {
+			return c.JSON(http.StatusNotFound, msg(c, "nothing_to_undo"))
+		}
+		return c.JSON(http.StatusOK, tasks)
+	})
+
+	// GET /tasks/:id.md, or GET /tasks/:id with Accept: text/markdown,
+	// returns the task rendered as Markdown.
+	// HEAD /tasks/:id answers the same headers without the body, so clients
+	// can check that a task exists or that their copy is current.
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/tasks/:id", func(c echo.Context) error {
+		param, markdown := strings.CutSuffix(c.Param("id"), ".md")
+		if !markdown {
+			markdown = strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/markdown")
+		}
+		id, err := parseID(param)
 		if err != nil {
 			return c.String(http.StatusBadRequest, err.Error())
 		}
-		_, err = bundb.NewDelete().Model((*Task)(nil)).Where(`"id" = ?`, id).Exec(context.Background())
+		task, err := store.Get(c.Request().Context(), id)
+		if err != nil {
+			return storeError(c, err)
+		}
+		etag := taskETag(task, markdown)
+		setCacheHeaders(c, etag, "no-cache")
+		c.Response().Header().Set("Last-Modified", task.UpdatedAt.UTC().Format(http.TimeFormat))
+		if c.Request().Header.Get("If-None-Match") == etag {
+			return c.NoContent(http.StatusNotModified)
+		}
+		if markdown {
+			return c.Blob(http.StatusOK, "text/markdown; charset=UTF-8", []byte(taskMarkdown(task)))
+		}
+		return c.JSON(http.StatusOK, task)
+	})
+
+	// /templates holds reusable tasks, which are kept apart from the tasks
+	// and instantiated with POST /templates/:id/instantiate.
+	e.POST("/templates", func(c echo.Context) error {
+		var tpl Template
+		if err := c.Bind(&tpl); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		tpl.ID = 0
+		if err := validateTemplate(&tpl); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		_, err := bundb.NewInsert().Model(&tpl).Exec(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, tpl)
+	})
+
+	e.GET("/templates", func(c echo.Context) error {
+		templates := []Template{}
+		err := bundb.NewSelect().Model(&templates).Order("id").Scan(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, templates)
+	})
+
+	// template returns the template of the :id parameter. The error is the
+	// response already sent when it is nil.
+	template := func(c echo.Context) (*Template, error) {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return nil, c.String(http.StatusBadRequest, err.Error())
+		}
+		var tpl Template
+		err = bundb.NewSelect().Model(&tpl).Where("id = ?", id).Scan(c.Request().Context())
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, c.JSON(http.StatusNotFound, msg(c, "template_not_found"))
+		}
+		if err != nil {
+			return nil, storeError(c, err)
+		}
+		return &tpl, nil
+	}
+
+	e.GET("/templates/:id", func(c echo.Context) error {
+		tpl, err := template(c)
+		if tpl == nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, tpl)
+	})
+
+	e.POST("/templates/:id", func(c echo.Context) error {
+		tpl, err := template(c)
+		if tpl == nil {
+			return err
+		}
+		id := tpl.ID
+		if err := c.Bind(tpl); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		tpl.ID = id
+		if err := validateTemplate(tpl); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		_, err = bundb.NewUpdate().Model(tpl).WherePK().Exec(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, tpl)
+	})
+
+	e.DELETE("/templates/:id", func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
 		if err != nil {
 			return c.String(http.StatusBadRequest, err.Error())
 		}
-		return c.JSON(http.StatusOK, id)
+		result, err := bundb.NewDelete().Model((*Template)(nil)).Where("id = ?", id).Exec(c.Request().Context())
+		if err != nil {
+			return storeError(c, err)
+		}
+		if num, err := result.RowsAffected(); err != nil || num == 0 {
+			return c.JSON(http.StatusNotFound, msg(c, "template_not_found"))
+		}
+		return c.NoContent(http.StatusNoContent)
 	})
-	e.GET("/tasks/:id", func(c echo.Context) error {
-		var task Task
-		err := bundb.NewSelect().Model((*Task)(nil)).Where("id = ?", c.Param("id")).Scan(context.Background(), &task)
+
+	e.POST("/templates/:id/instantiate", func(c echo.Context) error {
+		tpl, err := template(c)
+		if tpl == nil {
+			return err
+		}
+		task := tpl.newTask(defaults, time.Now())
+		err = store.Create(c.Request().Context(), &task)
+		if errors.Is(err, errTaskLimit) {
+			return c.JSON(http.StatusForbidden, msg(c, "task_limit", maxTasks))
+		}
 		if err != nil {
-			e.Logger.Error(err)
-			return c.JSON(http.StatusInternalServerError, err.Error())
+			return storeError(c, err)
 		}
-		return c.JSON(http.StatusOK, task)
+		return taskResponse(c, http.StatusOK, &task)
+	})
+
+	// ADMIN_TOKEN enables the /admin endpoints for operators. They are
+	// read-only unless ADMIN_ALLOW_CANCEL is set.
+	if adminToken != "" {
+		g := e.Group("/admin", adminAuth(adminToken))
+
+		// GET /admin/queries lists the other backends connected to the
+		// database, the longest running query first. Duration is in seconds.
+		g.GET("/queries", func(c echo.Context) error {
+			activities := []Activity{}
+			err := bundb.NewSelect().
+				TableExpr("pg_stat_activity").
+				ColumnExpr("pid, coalesce(application_name, '') AS application_name").
+				ColumnExpr("coalesce(state, '') AS state, coalesce(wait_event, '') AS wait_event").
+				ColumnExpr("query_start, coalesce(extract(epoch FROM now() - query_start), 0) AS duration, query").
+				Where("datname = current_database()").
+				Where("pid <> pg_backend_pid()").
+				OrderExpr("query_start NULLS LAST, pid").
+				Scan(c.Request().Context(), &activities)
+			if err != nil {
+				return storeError(c, err)
+			}
+			return c.JSON(http.StatusOK, activities)
+		})
+
+		// POST /admin/queries/:pid/cancel cancels the current query of the
+		// backend with pg_cancel_backend.
+		g.POST("/queries/:pid/cancel", func(c echo.Context) error {
+			if !allowCancel {
+				return c.JSON(http.StatusForbidden, msg(c, "admin_read_only"))
+			}
+			pid, err := strconv.ParseInt(c.Param("pid"), 10, 64)
+			if err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			var cancelled bool
+			err = bundb.NewSelect().
+				ColumnExpr("pg_cancel_backend(pid)").
+				TableExpr("pg_stat_activity").
+				Where("pid = ?", pid).
+				Where("datname = current_database()").
+				Scan(c.Request().Context(), &cancelled)
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, msg(c, "backend_not_found"))
+			}
+			if err != nil {
+				return storeError(c, err)
+			}
+			return c.JSON(http.StatusOK, cancelled)
+		})
+
+		// GET /admin/dbstats returns the current state of the connection
+		// pool, to tell whether it is sized for the load. Wait counts and
+		// durations are totals since the start.
+		g.GET("/dbstats", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, newDBStats(bundb.Stats()))
+		})
+
+		// GET /admin/backup returns a Backup of all the tasks, including
+		// the deleted ones, their dependencies and the templates.
+		g.GET("/backup", func(c echo.Context) error {
+			backup, err := dumpBackup(c.Request().Context(), bundb)
+			if err != nil {
+				return storeError(c, err)
+			}
+			c.Response().Header().Set(echo.HeaderContentDisposition,
+				fmt.Sprintf(`attachment; filename="%s-%s.json"`, name, backup.CreatedAt.UTC().Format("20060102T150405Z")))
+			return c.JSON(http.StatusOK, backup)
+		})
+
+		// POST /admin/restore loads a Backup from GET /admin/backup, ids
+		// included, in one transaction. It only restores into a database
+		// without tasks or templates and responds 409 Conflict otherwise,
+		// so existing rows are never overwritten or merged. MAX_TASKS and
+		// PRIORITY_LIMITS are not applied to the restored tasks.
+		g.POST("/restore", func(c echo.Context) error {
+			var backup Backup
+			if err := c.Bind(&backup); err != nil {
+				c.Logger().Error("Bind: ", err)
+				return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+			}
+			if err := validateBackup(&backup); err != nil {
+				return c.String(http.StatusBadRequest, errorMessage(c, err))
+			}
+			err := restoreBackup(c.Request().Context(), bundb, &backup)
+			if errors.Is(err, errRestoreNotEmpty) {
+				return c.JSON(http.StatusConflict, msg(c, "restore_not_empty"))
+			}
+			if err != nil {
+				return storeError(c, err)
+			}
+			return c.NoContent(http.StatusNoContent)
+		})
+	}
+
+	// GET /readyz reports whether the database and the READY_CHECK_URLS
+	// and READY_CHECK_DISKS dependencies are available.
+	if !demo {
+		readyChecks = append([]readyCheck{{name: "database", check: bundb.PingContext}}, readyChecks...)
+	}
+	e.GET("/readyz", readyHandler(readyChecks, readyTimeout))
+	// GET /metrics exposes the request and slow query counters in the
+	// Prometheus text format.
+	e.GET("/metrics", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		if _, err := requests.WriteTo(c.Response()); err != nil {
+			return err
+		}
+		_, err := slowQueries.WriteTo(c.Response())
+		return err
 	})
 
+	// GET /preferences returns the saved list view, with zero values when
+	// none was saved.
+	e.GET("/preferences", func(c echo.Context) error {
+		prefs, err := loadPreferences(c.Request().Context(), bundb)
+		if err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, prefs)
+	})
+
+	// PUT /preferences replaces the saved list view applied by GET /tasks,
+	// e.g. {"sort": "starred", "completed": false, "limit": 20}.
+	e.PUT("/preferences", func(c echo.Context) error {
+		var prefs Preferences
+		if err := c.Bind(&prefs); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		if err := validatePreferences(&prefs); err != nil {
+			return c.String(http.StatusBadRequest, errorMessage(c, err))
+		}
+		if err := savePreferences(c.Request().Context(), bundb, &prefs); err != nil {
+			return storeError(c, err)
+		}
+		return c.JSON(http.StatusOK, &prefs)
+	})
+
+	e.GET("/settings", settingsHandler(Settings{
+		Version: version,
+		Features: map[string]bool{
+			"due_dates":    true,
+			"priorities":   true,
+			"search":       true,
+			"graphql":      true,
+			"tags":         true,
+			"dependencies": !demo,
+			"templates":    !demo,
+			"comments":     !demo,
+			"activity":     !demo,
+			"undo":         undoWindow > 0,
+			"reminders":    reminders != nil,
+			"caldav":       caldavURL != "",
+			"api_keys":     len(apiKeys) > 0,
+		},
+		MaxTasks:        maxTasks,
+		MaxTextLength:   maxTextLength,
+		DefaultPageSize: defaultPageSize,
+		MaxIDs:          maxIDs,
+		MaxPriority:     maxPriority,
+		DefaultPriority: defaults.Priority,
+		PriorityLimits:  priorityLimits,
+		DefaultDueIn:    durationString(defaults.DueIn),
+		UndoWindow:      undoWindow.String(),
+		Colors:          colorNames,
+		Sorts:           slices.Sorted(maps.Keys(taskSorts)),
+	}))
+
+	e.Any("/graphql", echo.WrapHandler(&relay.Handler{
+		Schema: newGraphQLSchema(store, defaults),
+	}))
+
+	if favicon := os.Getenv("FAVICON"); favicon != "" {
+		e.File("/favicon.ico", favicon)
+	}
 	sub, _ := fs.Sub(assets, "assets")
-	e.GET("/*", echo.WrapHandler(http.FileServer(http.FS(sub))))
-	e.Logger.Fatal(e.Start(":8989"))
+	e.HTTPErrorHandler = staticErrorHandler(e, sub, errorPage)
+	if landingRedirect != "" {
+		e.GET("/", func(c echo.Context) error {
+			return c.Redirect(http.StatusFound, landingRedirect)
+		})
+	}
+	e.GET("/*", staticHandler(sub, staticMaxAge, notFoundPage))
+	return e, func() { bundb.Close() }, nil
 }
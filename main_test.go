@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestServer returns the server of DEMO_MODE, keeping the tasks in
+// memory, configured by env besides. Requests are not logged.
+func newTestServer(t *testing.T, env map[string]string) *echo.Echo {
+	t.Helper()
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("LOG_SAMPLE_RATE", "0")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	e, closeDB, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+	return e
+}
+
+// serve returns the response of e to req.
+func serve(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// createTasks creates n tasks with the JSON body and returns their ids.
+func createTasks(t *testing.T, e *echo.Echo, n int, body string) []int64 {
+	t.Helper()
+	var ids []int64
+	for range n {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := serve(e, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST /tasks = %d: %s", rec.Code, rec.Body)
+		}
+		var task Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestDeleteTask(t *testing.T) {
+	e := newTestServer(t, nil)
+	ids := createTasks(t, e, 1, `{"text":"delete me"}`)
+	path := fmt.Sprintf("/tasks/%d", ids[0])
+	if rec := serve(e, httptest.NewRequest(http.MethodDelete, path, nil)); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE %s = %d, want %d", path, rec.Code, http.StatusNoContent)
+	}
+	for _, path := range []string{path, "/tasks/12345"} {
+		if rec := serve(e, httptest.NewRequest(http.MethodDelete, path, nil)); rec.Code != http.StatusNotFound {
+			t.Errorf("DELETE %s = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestHeadTask(t *testing.T) {
+	e := newTestServer(t, nil)
+	ids := createTasks(t, e, 1, `{"text":"head"}`)
+	// the server drops the bodies of HEAD responses, which a recorder
+	// would keep.
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	path := fmt.Sprintf("%s/tasks/%d", srv.URL, ids[0])
+	get, err := http.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+	head, err := http.Head(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(head.Body)
+	head.Body.Close()
+	if head.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("HEAD = %d with %d bytes, want %d without a body", head.StatusCode, len(body), http.StatusOK)
+	}
+	for _, h := range []string{"ETag", "Last-Modified", "Cache-Control", "Content-Type"} {
+		if head.Header.Get(h) == "" || head.Header.Get(h) != get.Header.Get(h) {
+			t.Errorf("HEAD %s = %q, want %q as GET", h, head.Header.Get(h), get.Header.Get(h))
+		}
+	}
+	missing, err := http.Head(srv.URL + "/tasks/12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a missing task = %d, want %d", missing.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	e := newTestServer(t, nil)
+	ids := createTasks(t, e, 23, `{"text":"same","priority":2}`)
+	var got []int64
+	for offset := 0; offset < len(ids)+5; offset += 5 {
+		path := fmt.Sprintf("/tasks?sort=focus&limit=5&offset=%d", offset)
+		rec := serve(e, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+		}
+		var tasks []Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			got = append(got, task.ID)
+		}
+	}
+	if !slices.Equal(got, ids) {
+		t.Errorf("pages = %v, want %v", got, ids)
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{errTaskNotFound, http.StatusNotFound},
+		{errTaskBlocked, http.StatusConflict},
+		{fmt.Errorf("update: %w", driver.ErrBadConn), http.StatusServiceUnavailable},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, http.StatusServiceUnavailable},
+		{errors.New("failed"), http.StatusInternalServerError},
+	}
+	e := echo.New()
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/tasks", nil), rec)
+		if err := storeError(c, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.code {
+			t.Errorf("storeError(%v) = %d, want %d", tt.err, rec.Code, tt.code)
+		}
+		if retry := rec.Header().Get("Retry-After"); (tt.code == http.StatusServiceUnavailable) != (retry != "") {
+			t.Errorf("storeError(%v): Retry-After = %q", tt.err, retry)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	e := newTestServer(t, map[string]string{"MAX_TASK_LENGTH": "10", "DEFAULT_PAGE_SIZE": "2", "UNDO_WINDOW": "0s"})
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/settings", nil))
+	var settings Settings
+	if err := json.Unmarshal(rec.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxTextLength != 10 || settings.DefaultPageSize != 2 {
+		t.Errorf("max_text_length, default_page_size = %d, %d, want 10, 2", settings.MaxTextLength, settings.DefaultPageSize)
+	}
+	for name, want := range map[string]bool{"search": true, "templates": false, "undo": false, "reminders": false} {
+		if settings.Features[name] != want {
+			t.Errorf("features[%q] = %v, want %v", name, settings.Features[name], want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"text":"longer than ten"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if rec := serve(e, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /tasks with a long text = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	createTasks(t, e, 3, `{"text":"short"}`)
+	for path, want := range map[string]int{"/tasks": 2, "/tasks?limit=0": 3} {
+		var tasks []Task
+		if err := json.Unmarshal(serve(e, httptest.NewRequest(http.MethodGet, path, nil)).Body.Bytes(), &tasks); err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != want {
+			t.Errorf("GET %s = %d tasks, want %d", path, len(tasks), want)
+		}
+	}
+}
+
+func TestUndoBulkDelete(t *testing.T) {
+	e := newTestServer(t, nil)
+	ids := createTasks(t, e, 5, `{"text":"bulk"}`)
+	body, _ := json.Marshal(map[string]any{"ids": ids[1:]})
+	req := httptest.NewRequest(http.MethodPost, "/tasks/bulk/delete", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if rec := serve(e, req); rec.Code != http.StatusOK {
+		t.Fatalf("POST /tasks/bulk/delete = %d: %s", rec.Code, rec.Body)
+	}
+	rec := serve(e, httptest.NewRequest(http.MethodPost, "/tasks/undo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /tasks/undo = %d: %s", rec.Code, rec.Body)
+	}
+	var restored []Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &restored); err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, task := range restored {
+		got = append(got, task.ID)
+	}
+	if !slices.Equal(got, ids[1:]) {
+		t.Errorf("restored %v, want %v", got, ids[1:])
+	}
+	if rec := serve(e, httptest.NewRequest(http.MethodPost, "/tasks/undo", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("second POST /tasks/undo = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHighlightEscaped(t *testing.T) {
+	e := newTestServer(t, nil)
+	createTasks(t, e, 1, `{"text":"<script>alert(1)</script> report"}`)
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks?q=report&highlight=true", nil))
+	var tasks []Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("GET /tasks?q=report = %d tasks, want 1", len(tasks))
+	}
+	if want := "&lt;script&gt;alert(1)&lt;/script&gt; <mark>report</mark>"; tasks[0].Highlight != want {
+		t.Errorf("highlight = %q, want %q", tasks[0].Highlight, want)
+	}
+}
+
+func TestReorderBadID(t *testing.T) {
+	db, conn := newRecordingDB()
+	e := echo.New()
+	e.POST("/tasks/:id/reorder", reorderHandler(db))
+	req := httptest.NewRequest(http.MethodPost, "/tasks/1%20OR%201=1/reorder", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if rec := serve(e, req); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if len(conn.events) != 0 {
+		t.Errorf("queries = %q, want none", conn.events)
+	}
+}
+
+// TestDemoWithoutDatabase checks that demo mode leaves out the features
+// kept in the database besides the routes of requireDatabase.
+func TestDemoWithoutDatabase(t *testing.T) {
+	e := newTestServer(t, map[string]string{
+		"API_KEYS":          "secret",
+		"API_QUOTA":         "1",
+		"SMTP_ADDR":         "localhost:25",
+		"SMTP_FROM":         "todo@example.com",
+		"REMINDER_EMAIL_TO": "me@example.com",
+	})
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"text":"write"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-API-Key", "secret")
+		rec := serve(e, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST /tasks = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+		}
+		if limit := rec.Header().Get("X-RateLimit-Limit"); limit != "" {
+			t.Errorf("X-RateLimit-Limit = %q, want none", limit)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.Header.Set("X-API-Key", "secret")
+	var settings Settings
+	if err := json.Unmarshal(serve(e, req).Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Features["reminders"] {
+		t.Error("features[reminders] = true, want false")
+	}
+}
+
+func TestCreateStatus(t *testing.T) {
+	e := newTestServer(t, nil)
+	tests := []struct {
+		name, path, body string
+		code             int
+	}{
+		{"create", "/tasks", `{"text":"write"}`, http.StatusCreated},
+		{"upsert create", "/tasks?upsert=true", `{"text":"sync","external_id":"a"}`, http.StatusCreated},
+		{"upsert update", "/tasks?upsert=true", `{"text":"synced","external_id":"a"}`, http.StatusOK},
+		{"quickadd", "/tasks/quickadd", `{"text":"call !high"}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := serve(e, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		var task Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if tt.code == http.StatusCreated {
+			want = fmt.Sprintf("/tasks/%d", task.ID)
+		}
+		if got := rec.Header().Get("Location"); got != want {
+			t.Errorf("%s: Location = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestUUIDScheme(t *testing.T) {
+	e := newTestServer(t, map[string]string{"TASK_ID_SCHEME": "uuid", "TASK_ID_KEY": "secret"})
+	t.Cleanup(func() { idCipher = nil })
+	var ids []string
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"text":"write"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := serve(e, req)
+		var task struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+			t.Fatalf("POST /tasks = %d %s: %v", rec.Code, rec.Body, err)
+		}
+		if len(task.ID) != 36 || rec.Header().Get("Location") != "/tasks/"+task.ID {
+			t.Fatalf("id = %q, Location = %q", task.ID, rec.Header().Get("Location"))
+		}
+		ids = append(ids, task.ID)
+	}
+	if rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks/"+ids[0], nil)); rec.Code != http.StatusOK {
+		t.Errorf("GET /tasks/%s = %d, want %d", ids[0], rec.Code, http.StatusOK)
+	}
+	if rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks/1", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /tasks/1 = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	for body, code := range map[string]int{
+		`{"ids":[1]}`:                       http.StatusBadRequest,
+		fmt.Sprintf(`{"ids":[%q]}`, ids[1]): http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/bulk/delete", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if rec := serve(e, req); rec.Code != code {
+			t.Errorf("POST /tasks/bulk/delete %s = %d, want %d: %s", body, rec.Code, code, rec.Body)
+		}
+	}
+	if rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks/"+ids[1], nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("GET of the deleted task = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSnoozeTimeZone(t *testing.T) {
+	e := newTestServer(t, nil)
+	id := createTasks(t, e, 1, `{"text":"call"}`)[0]
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Now().In(loc)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/tasks/%d/snooze?tz=Asia/Tokyo", id), strings.NewReader(`{"preset":"tomorrow"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := serve(e, req)
+	var task Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+		t.Fatalf("POST /tasks/%d/snooze = %d %s: %v", id, rec.Code, rec.Body, err)
+	}
+	want := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	if task.DueDate == nil || !task.DueDate.Equal(want) {
+		t.Errorf("due_date = %v, want %v", task.DueDate, want)
+	}
+}
+
+func TestImportSize(t *testing.T) {
+	e := newTestServer(t, map[string]string{"MAX_IMPORT_TASKS": "2"})
+	for body, code := range map[string]int{"a\nb\n": http.StatusOK, "a\nb\nc\n": http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/import-text", strings.NewReader(body))
+		if rec := serve(e, req); rec.Code != code {
+			t.Errorf("POST /tasks/import-text %q = %d, want %d: %s", body, rec.Code, code, rec.Body)
+		}
+	}
+}
+
+func TestResponseCacheWrite(t *testing.T) {
+	e := newTestServer(t, map[string]string{"RESPONSE_CACHE": "/tasks=1m"})
+	list := func() ([]Task, bool) {
+		t.Helper()
+		rec := serve(e, httptest.NewRequest(http.MethodGet, "/tasks", nil))
+		var tasks []Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("GET /tasks = %d %s: %v", rec.Code, rec.Body, err)
+		}
+		return tasks, rec.Header().Get("Age") != ""
+	}
+	if _, cached := list(); cached {
+		t.Fatal("first GET /tasks served from the cache")
+	}
+	if _, cached := list(); !cached {
+		t.Fatal("second GET /tasks not served from the cache")
+	}
+	createTasks(t, e, 1, `{"text":"write"}`)
+	tasks, cached := list()
+	if cached || len(tasks) != 1 {
+		t.Errorf("GET /tasks after a write: cached %v with %d tasks, want a fresh response with 1", cached, len(tasks))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "\n", " ",
+)
+
+// formatIDs returns the ids as "#1, #2".
+func formatIDs(ids []int64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = "#" + formatID(id)
+	}
+	return strings.Join(s, ", ")
+}
+
+// taskMarkdown renders the task as a Markdown checklist item with its
+// details as a nested list.
+func taskMarkdown(t *Task) string {
+	var b strings.Builder
+	check := " "
+	if t.Completed {
+		check = "x"
+	}
+	fmt.Fprintf(&b, "- [%s] %s\n", check, markdownEscaper.Replace(t.Text))
+	if t.DueDate != nil {
+		fmt.Fprintf(&b, "  - Due: %s\n", t.DueDate.Format(time.RFC3339))
+	}
+	if t.Priority > 0 {
+		fmt.Fprintf(&b, "  - Priority: %d\n", t.Priority)
+	}
+	if t.Starred {
+		b.WriteString("  - Starred\n")
+	}
+	if t.Color != "" {
+		fmt.Fprintf(&b, "  - Color: %s\n", markdownEscaper.Replace(t.Color))
+	}
+	if len(t.BlockedBy) > 0 {
+		fmt.Fprintf(&b, "  - Blocked by: %s\n", formatIDs(t.BlockedBy))
+	}
+	if len(t.Blocks) > 0 {
+		fmt.Fprintf(&b, "  - Blocks: %s\n", formatIDs(t.Blocks))
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// mergeTask merges the fields of source into target:
+//
+//   - the text of source is appended to the text of target on a new
+//     paragraph, unless they are the same by sameText;
+//   - the task is completed only when both are, as of the latest
+//     completion;
+//   - the earliest due date, the highest priority and the largest
+//     estimate are kept, and the task is starred when either is;
+//   - the color, the reminder offset and the person waited on of target
+//     are kept unless it has none;
+//   - the metadata keys of target win, except for "tags" whose arrays are
+//     combined;
+//   - the checklist items of source with a text not in target are added
+//     after those of target.
+func mergeTask(target, source *Task) {
+	if !sameText(target.Text, source.Text) {
+		target.Text += "\n\n" + source.Text
+	}
+	target.Completed = target.Completed && source.Completed
+	if !target.Completed {
+		target.CompletedAt = nil
+	} else if source.CompletedAt != nil && (target.CompletedAt == nil || source.CompletedAt.After(*target.CompletedAt)) {
+		target.CompletedAt = source.CompletedAt
+	}
+	if source.DueDate != nil && (target.DueDate == nil || source.DueDate.Before(*target.DueDate)) {
+		target.DueDate = source.DueDate
+	}
+	target.Priority = max(target.Priority, source.Priority)
+	if source.EstimateMinutes != nil && (target.EstimateMinutes == nil || *source.EstimateMinutes > *target.EstimateMinutes) {
+		target.EstimateMinutes = source.EstimateMinutes
+	}
+	target.Starred = target.Starred || source.Starred
+	if target.Color == "" {
+		target.Color = source.Color
+	}
+	if target.ReminderMinutes == nil {
+		target.ReminderMinutes = source.ReminderMinutes
+	}
+	if target.WaitingFor == "" {
+		target.WaitingFor = source.WaitingFor
+	}
+
+	if len(source.Metadata) > 0 {
+		metadata := maps.Clone(source.Metadata)
+		maps.Copy(metadata, target.Metadata)
+		a, b := taskTags(target), taskTags(source)
+		if a != nil && b != nil {
+			tags := slices.Clone(a)
+			for _, tag := range b {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			metadata["tags"] = tags
+		}
+		target.Metadata = metadata
+	}
+
+	for _, item := range source.Checklist {
+		if !slices.ContainsFunc(target.Checklist, func(i ChecklistItem) bool { return sameText(i.Text, item.Text) }) {
+			target.Checklist = append(target.Checklist, item)
+		}
+	}
+}
+
+// mergeTasks merges the task source into the task target in one
+// transaction, see mergeTask. The comments and dependencies of source are
+// moved to target and source is deleted, so POST /tasks/undo brings it
+// back, without them.
+func mergeTasks(ctx context.Context, db bun.IDB, targetID, sourceID int64) error {
+	return withTx(ctx, db, func(ctx context.Context, tx bun.Tx) error {
+		// like POST /tasks/:id/blockers, so a cycle cannot be closed
+		// meanwhile.
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE "TaskDependency" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		var tasks []Task
+		err := tx.NewSelect().Model(&tasks).
+			Where("id IN (?)", bun.In([]int64{targetID, sourceID})).
+			Order("id").
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 2 {
+			return errTaskNotFound
+		}
+		target, source := &tasks[0], &tasks[1]
+		if target.ID != targetID {
+			target, source = source, target
+		}
+
+		mergeTask(target, source)
+		if err := validateTask(target); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		target.UpdatedAt = time.Now()
+		if _, err := tx.NewUpdate().Model(target).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().Model((*Comment)(nil)).
+			Set("task_id = ?", targetID).
+			Where("task_id = ?", sourceID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewRaw(`
+			INSERT INTO "TaskDependency" (task_id, blocker_id)
+			SELECT CASE WHEN task_id = ? THEN ? ELSE task_id END, CASE WHEN blocker_id = ? THEN ? ELSE blocker_id END
+			FROM "TaskDependency"
+			WHERE (task_id = ? AND blocker_id <> ?) OR (blocker_id = ? AND task_id <> ?)
+			ON CONFLICT DO NOTHING`,
+			sourceID, targetID, sourceID, targetID,
+			sourceID, targetID, sourceID, targetID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model((*TaskDependency)(nil)).
+			WhereOr("task_id = ?", sourceID).
+			WhereOr("blocker_id = ?", sourceID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		cycle, err := dependsOn(ctx, tx, targetID, targetID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return echo.NewHTTPError(http.StatusConflict, newError("dependency_cycle"))
+		}
+
+		_, err = tx.NewDelete().Model(source).WherePK().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// slowQueryMetrics is a bun.QueryHook counting the queries that took at
+// least threshold, by operation, for GET /metrics.
+type slowQueryMetrics struct {
+	threshold time.Duration
+
+	mu      sync.Mutex
+	counts  map[string]uint64
+	seconds map[string]float64
+}
+
+func newSlowQueryMetrics(threshold time.Duration) *slowQueryMetrics {
+	return &slowQueryMetrics{threshold: threshold, counts: map[string]uint64{}, seconds: map[string]float64{}}
+}
+
+func (m *slowQueryMetrics) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (m *slowQueryMetrics) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	d := time.Since(event.StartTime)
+	if d < m.threshold {
+		return
+	}
+	op := strings.ToUpper(event.Operation())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[op]++
+	m.seconds[op] += d.Seconds()
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *slowQueryMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := slices.Sorted(maps.Keys(m.counts))
+	var n int
+	write := func(format string, args ...any) {
+		k, _ := fmt.Fprintf(w, format, args...)
+		n += k
+	}
+	write("# HELP todoapp_slow_query_threshold_seconds Duration from which a query counts as slow.\n")
+	write("# TYPE todoapp_slow_query_threshold_seconds gauge\n")
+	write("todoapp_slow_query_threshold_seconds %g\n", m.threshold.Seconds())
+	write("# HELP todoapp_slow_queries_total Queries taking at least the threshold, by operation.\n")
+	write("# TYPE todoapp_slow_queries_total counter\n")
+	for _, op := range ops {
+		write("todoapp_slow_queries_total{operation=%q} %d\n", op, m.counts[op])
+	}
+	write("# HELP todoapp_slow_query_seconds_total Time spent in the slow queries, by operation.\n")
+	write("# TYPE todoapp_slow_query_seconds_total counter\n")
+	for _, op := range ops {
+		write("todoapp_slow_query_seconds_total{operation=%q} %g\n", op, m.seconds[op])
+	}
+	return int64(n), nil
+}
+
+// requestLabels are the labels of todoapp_http_requests_total.
+type requestLabels struct {
+	class string
+	// kind is validation for the 400 and 422 of the bad requests the
+	// handlers validate, client for the other 4xx, server for the 5xx,
+	// and none otherwise.
+	kind string
+}
+
+// requestMetrics counts the requests by status class and kind of error,
+// for GET /metrics.
+type requestMetrics struct {
+	mu     sync.Mutex
+	counts map[requestLabels]uint64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{counts: map[requestLabels]uint64{}}
+}
+
+// middleware counts the requests. The status of an error returned by the
+// handler is the one the HTTPErrorHandler answers with.
+func (m *requestMetrics) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		code := c.Response().Status
+		if err != nil && !c.Response().Committed {
+			code = http.StatusInternalServerError
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+		}
+		key := requestLabels{class: fmt.Sprintf("%dxx", code/100), kind: "none"}
+		switch {
+		case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+			key.kind = "validation"
+		case code >= 400 && code < 500:
+			key.kind = "client"
+		case code >= 500:
+			key.kind = "server"
+		}
+		m.mu.Lock()
+		m.counts[key]++
+		m.mu.Unlock()
+		return err
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *requestMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := slices.SortedFunc(maps.Keys(m.counts), func(a, b requestLabels) int {
+		return strings.Compare(a.class+a.kind, b.class+b.kind)
+	})
+	var n int
+	write := func(format string, args ...any) {
+		k, _ := fmt.Fprintf(w, format, args...)
+		n += k
+	}
+	write("# HELP todoapp_http_requests_total Requests by status class and kind of error.\n")
+	write("# TYPE todoapp_http_requests_total counter\n")
+	for _, k := range keys {
+		write("todoapp_http_requests_total{class=%q,error=%q} %d\n", k.class, k.kind, m.counts[k])
+	}
+	return int64(n), nil
+}
+
+// writeTaskMetrics writes the counts of tasks in the OpenMetrics text
+// format: in total, by status as in ?status=, the open ones by priority,
+// and the open ones overdue at now. Each is one count query.
+func writeTaskMetrics(ctx context.Context, w io.Writer, store TaskStore, now time.Time) error {
+	completed, open, waiting, active := true, false, true, false
+	count := func(f TaskFilter) (int, error) { return store.Count(ctx, f) }
+	total, err := count(TaskFilter{})
+	if err != nil {
+		return err
+	}
+	statuses := []struct {
+		name string
+		f    TaskFilter
+	}{
+		{"active", TaskFilter{Completed: &open, Waiting: &active}},
+		{"waiting", TaskFilter{Completed: &open, Waiting: &waiting}},
+		{"completed", TaskFilter{Completed: &completed}},
+	}
+	byStatus := make([]int, len(statuses))
+	for i, s := range statuses {
+		if byStatus[i], err = count(s.f); err != nil {
+			return err
+		}
+	}
+	byPriority := make([]int, maxPriority+1)
+	for p := range byPriority {
+		priority := p
+		if byPriority[p], err = count(TaskFilter{Completed: &open, Priority: &priority}); err != nil {
+			return err
+		}
+	}
+	overdue, err := count(TaskFilter{Completed: &open, DueBefore: &now})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, "# TYPE todoapp_tasks gauge\n# HELP todoapp_tasks Tasks not deleted.\n")
+	fmt.Fprintf(w, "todoapp_tasks %d\n", total)
+	fmt.Fprint(w, "# TYPE todoapp_tasks_by_status gauge\n# HELP todoapp_tasks_by_status Tasks by status.\n")
+	for i, s := range statuses {
+		fmt.Fprintf(w, "todoapp_tasks_by_status{status=%q} %d\n", s.name, byStatus[i])
+	}
+	fmt.Fprint(w, "# TYPE todoapp_open_tasks_by_priority gauge\n# HELP todoapp_open_tasks_by_priority Open tasks by priority.\n")
+	for p, n := range byPriority {
+		fmt.Fprintf(w, "todoapp_open_tasks_by_priority{priority=\"%d\"} %d\n", p, n)
+	}
+	fmt.Fprint(w, "# TYPE todoapp_overdue_tasks gauge\n# HELP todoapp_overdue_tasks Open tasks past their due date.\n")
+	fmt.Fprintf(w, "todoapp_overdue_tasks %d\n", overdue)
+	_, err = fmt.Fprint(w, "# EOF\n")
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// streamNDJSON writes the tasks matching f as newline delimited JSON,
+// flushing after each task. An error after the first task cannot change
+// the status anymore and ends the stream early. Long texts get a preview
+// of previewLength characters unless it is zero.
+func streamNDJSON(c echo.Context, store TaskStore, f TaskFilter, previewLength int) error {
+	res := c.Response()
+	start := func() {
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+			res.WriteHeader(http.StatusOK)
+		}
+	}
+	enc := json.NewEncoder(res)
+	rc := http.NewResponseController(res)
+	err := store.Each(c.Request().Context(), f, func(task *Task) error {
+		start()
+		if previewLength > 0 {
+			task.Preview = preview(task.Text, previewLength)
+		}
+		var v any = task
+		if idCipher != nil {
+			b, err := rewriteJSON(task, publicIDs)
+			if err != nil {
+				return err
+			}
+			v = b
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// not every writer can flush, e.g. under REQUEST_TIMEOUT.
+		_ = rc.Flush()
+		return nil
+	})
+	if err != nil && !res.Committed {
+		return storeError(c, err)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return nil
+	}
+	start()
+	return nil
+}
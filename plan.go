@@ -0,0 +1,63 @@
+package main
+
+// maxPlanMinutes is the largest time budget of GET /tasks/plan, which bounds
+// the memory of the knapsack to tasks × minutes.
+const maxPlanMinutes = 24 * 60
+
+// Plan is the response of GET /tasks/plan.
+type Plan struct {
+	Tasks []Task `json:"tasks"`
+	// Minutes is the sum of the estimates of the tasks, at most Available.
+	Minutes   int `json:"minutes"`
+	Available int `json:"available"`
+	// Unestimated is the number of pending tasks left out for having no
+	// estimate.
+	Unestimated int `json:"unestimated"`
+}
+
+// planTasks picks the subset of tasks whose estimates fit in minutes with
+// the largest total value, solving the 0/1 knapsack exactly by dynamic
+// programming. The value of a task is its priority plus one, so that tasks
+// without a priority still fill the time left. Tasks without an estimate
+// are left out. Of the subsets with the same value the first found wins, so
+// the plan only depends on the tasks and their order, which it keeps.
+func planTasks(tasks []Task, minutes int) Plan {
+	plan := Plan{Tasks: []Task{}, Available: minutes}
+	var candidates []Task
+	for _, task := range tasks {
+		switch {
+		case task.EstimateMinutes == nil:
+			plan.Unestimated++
+		case *task.EstimateMinutes <= minutes:
+			candidates = append(candidates, task)
+		}
+	}
+	// best[w] is the largest value fitting in w minutes with the tasks
+	// seen so far, and take[i][w] whether it takes the task i.
+	best := make([]int, minutes+1)
+	take := make([][]bool, len(candidates))
+	for i, task := range candidates {
+		take[i] = make([]bool, minutes+1)
+		cost, value := *task.EstimateMinutes, task.Priority+1
+		for w := minutes; w >= cost; w-- {
+			if v := best[w-cost] + value; v > best[w] {
+				best[w] = v
+				take[i][w] = true
+			}
+		}
+	}
+	picked := make([]bool, len(candidates))
+	for i, w := len(candidates)-1, minutes; i >= 0; i-- {
+		if take[i][w] {
+			picked[i] = true
+			w -= *candidates[i].EstimateMinutes
+		}
+	}
+	for i, task := range candidates {
+		if picked[i] {
+			plan.Tasks = append(plan.Tasks, task)
+			plan.Minutes += *task.EstimateMinutes
+		}
+	}
+	return plan
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// Preferences is the saved list view of GET and PUT /preferences. The list
+// endpoints apply it to the parameters a request leaves out. There are no
+// users, so the preferences are the server's and stored in a single row.
+type Preferences struct {
+	bun.BaseModel `bun:"table:Preferences,alias:pf"`
+
+	ID        int64  `bun:"id,pk" json:"-"`
+	Sort      string `bun:"sort,notnull,default:''" json:"sort"`
+	Completed *bool  `bun:"completed" json:"completed"`
+	Starred   *bool  `bun:"starred" json:"starred"`
+	// Limit is the page size; zero lists all tasks.
+	Limit     int       `bun:"limit,notnull,default:0" json:"limit"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+// validatePreferences checks the fields of preferences about to be saved.
+func validatePreferences(p *Preferences) error {
+	if _, ok := taskSorts[p.Sort]; !ok {
+		return newError("invalid_sort", p.Sort)
+	}
+	if p.Limit < 0 {
+		return newError("invalid_param", "limit")
+	}
+	return nil
+}
+
+// loadPreferences returns the saved preferences, or the zero ones when
+// none were saved.
+func loadPreferences(ctx context.Context, db bun.IDB) (*Preferences, error) {
+	p := &Preferences{ID: 1}
+	err := db.NewSelect().Model(p).WherePK().Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+	return p, err
+}
+
+// savePreferences replaces the saved preferences with p.
+func savePreferences(ctx context.Context, db bun.IDB, p *Preferences) error {
+	p.ID, p.UpdatedAt = 1, time.Now()
+	_, err := db.NewInsert().Model(p).On("CONFLICT (id) DO UPDATE").
+		Set("sort = EXCLUDED.sort").
+		Set("completed = EXCLUDED.completed").
+		Set("starred = EXCLUDED.starred").
+		Set(`"limit" = EXCLUDED."limit"`).
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// apply sets the fields of f whose query parameter the request omits.
+func (p *Preferences) apply(c echo.Context, f *TaskFilter) {
+	params := c.QueryParams()
+	if !params.Has("sort") {
+		f.Sort = p.Sort
+	}
+	if !params.Has("completed") {
+		f.Completed = p.Completed
+	}
+	if !params.Has("starred") {
+		f.Starred = p.Starred
+	}
+	if !params.Has("limit") {
+		f.Limit = p.Limit
+	}
+}
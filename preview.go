@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// previewEllipsis ends a truncated preview.
+const previewEllipsis = "…"
+
+// extendsCluster reports whether r continues the character started before
+// it rather than starting a new one, for the sequences that make up most
+// user-perceived characters: combining marks, variation selectors, emoji
+// modifiers and tags, and whatever follows a zero width joiner.
+func extendsCluster(prev, r rune) bool {
+	switch {
+	case prev == '\u200d' || r == '\u200d':
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= '\ufe00' && r <= '\ufe0f':
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		return true
+	case r >= 0xe0020 && r <= 0xe007f:
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// preview returns s cut to at most n characters followed by an ellipsis,
+// or an empty string when s is not longer than n. It never splits a
+// multibyte rune, and keeps emoji sequences, flags and combining marks
+// together with the character they belong to.
+func preview(s string, n int) string {
+	count := 0
+	var prev rune
+	regional := false
+	for i, r := range s {
+		switch {
+		case i > 0 && extendsCluster(prev, r):
+		case regional && isRegionalIndicator(r):
+			// the second half of a flag.
+			regional = false
+		default:
+			if count == n {
+				return strings.TrimRightFunc(s[:i], unicode.IsSpace) + previewEllipsis
+			}
+			count++
+			regional = isRegionalIndicator(r)
+		}
+		prev = r
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PriorityLimits are the maximum numbers of open tasks per priority, see
+// PRIORITY_LIMITS. Priorities without a limit are not limited.
+type PriorityLimits map[int]int
+
+// parsePriorityLimits parses limits written as "3:2,2:5", meaning at most
+// two open tasks of priority 3 and five of priority 2.
+func parsePriorityLimits(s string) (PriorityLimits, error) {
+	limits := PriorityLimits{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q", kv)
+		}
+		priority, err := strconv.Atoi(k)
+		if err != nil || !validPriority(priority) {
+			return nil, fmt.Errorf("invalid priority %q", k)
+		}
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+		limits[priority] = limit
+	}
+	return limits, nil
+}
+
+// priorityLimitError is returned when a task would exceed the limit of
+// open tasks of its priority.
+type priorityLimitError struct {
+	priority, limit int
+}
+
+func (e *priorityLimitError) Error() string {
+	return fmt.Sprintf("limit of %d open tasks of priority %d reached", e.limit, e.priority)
+}
+
+// check returns a priorityLimitError if a task becoming open with the
+// priority would exceed its limit given the number of other open tasks of
+// that priority, counted by count.
+func (l PriorityLimits) check(priority int, count func() (int, error)) error {
+	limit, ok := l[priority]
+	if !ok {
+		return nil
+	}
+	n, err := count()
+	if err != nil {
+		return err
+	}
+	if n >= limit {
+		return &priorityLimitError{priority: priority, limit: limit}
+	}
+	return nil
+}
+
+// entersLimit reports whether the task counts against the limit of its
+// priority now when it did not before, having been completed or had
+// another priority.
+func entersLimit(t *Task, wasCompleted bool, wasPriority int) bool {
+	return !t.Completed && (wasCompleted || wasPriority != t.Priority)
+}
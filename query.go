@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseTimeZone returns the location of the ?tz= parameter, UTC when it is
+// missing. Only IANA names known to both Go and Postgres are accepted.
+func parseTimeZone(c echo.Context) (*time.Location, error) {
+	tz := c.QueryParam("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || loc == time.Local {
+		return nil, newError("invalid_param", "tz")
+	}
+	return loc, nil
+}
+
+// parseTaskFilter reads the filter, sort and paging query parameters shared
+// by the list endpoints.
+func parseTaskFilter(c echo.Context) (TaskFilter, error) {
+	var f TaskFilter
+	for name, p := range map[string]**bool{"completed": &f.Completed, "starred": &f.Starred} {
+		if s := c.QueryParam(name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return f, newError("invalid_param", name)
+			}
+			*p = &b
+		}
+	}
+	// ?status=waiting matches the pending tasks waiting on someone,
+	// ?status=active the other pending tasks and ?status=completed the
+	// completed ones.
+	switch status := c.QueryParam("status"); status {
+	case "":
+	case "waiting", "active":
+		completed, waiting := false, status == "waiting"
+		f.Completed, f.Waiting = &completed, &waiting
+	case "completed":
+		completed := true
+		f.Completed = &completed
+	default:
+		return f, newError("invalid_param", "status")
+	}
+	// ?metadata={"key":"value"} matches tasks whose metadata contains the
+	// given object.
+	if s := c.QueryParam("metadata"); s != "" {
+		if err := json.Unmarshal([]byte(s), &f.Metadata); err != nil || f.Metadata == nil {
+			return f, newError("invalid_param", "metadata")
+		}
+	}
+	// ?active_on=YYYY-MM-DD leaves out the tasks starting after that day
+	// in the ?tz= time zone.
+	if s := c.QueryParam("active_on"); s != "" {
+		loc, err := parseTimeZone(c)
+		if err != nil {
+			return f, err
+		}
+		day, err := time.ParseInLocation(time.DateOnly, s, loc)
+		if err != nil {
+			return f, newError("invalid_param", "active_on")
+		}
+		next := day.AddDate(0, 0, 1)
+		f.StartedBefore = &next
+	}
+	f.Query = c.QueryParam("q")
+	if s := c.QueryParam("highlight"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, newError("invalid_param", "highlight")
+		}
+		f.Highlight = b && f.Query != ""
+	}
+	if s := c.QueryParam("ids"); s != "" {
+		ids, err := parseIDs(s)
+		if err != nil {
+			return f, err
+		}
+		f.IDs = ids
+	}
+	// ?include=comments loads the related rows of taskRelations.
+	if s := c.QueryParam("include"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			if _, ok := taskRelations[name]; !ok {
+				return f, newError("invalid_include", name)
+			}
+			if !slices.Contains(f.Include, name) {
+				f.Include = append(f.Include, name)
+			}
+		}
+	}
+	f.Sort = c.QueryParam("sort")
+	if _, ok := taskSorts[f.Sort]; !ok {
+		return f, newError("invalid_sort", f.Sort)
+	}
+	for name, p := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+		if s := c.QueryParam(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return f, newError("invalid_param", name)
+			}
+			*p = n
+		}
+	}
+	return f, nil
+}
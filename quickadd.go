@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// quickAddPriorities are the priority names of the quick-add syntax.
+var quickAddPriorities = map[string]int{"none": 0, "low": 1, "medium": 2, "high": 3}
+
+// quickAdd parses the quick-add syntax of POST /tasks/quickadd. The text is
+// split into words, and the words of the following forms are taken out of
+// it into the fields of the task:
+//
+//   - priority prefix + none, low, medium, high or 0 to maxPriority, e.g.
+//     "!high", sets the priority;
+//   - tag prefix + name, e.g. "#groceries", adds the name to the "tags"
+//     array of the metadata;
+//   - today, tomorrow, a weekday name such as "friday" or "fri" (the next
+//     one, never today) or a YYYY-MM-DD date sets the due date to the
+//     start of that day.
+//
+// Words are matched case-insensitively. When a field is given twice the
+// last word wins. The other words are kept as the text, joined by single
+// spaces.
+type quickAdd struct {
+	priorityPrefix string
+	tagPrefix      string
+}
+
+// parse fills task from text, with dates relative to now in its location.
+func (q *quickAdd) parse(task *Task, text string, now time.Time) {
+	var words, tags []string
+	for _, word := range strings.Fields(text) {
+		lower := strings.ToLower(word)
+		if s, ok := strings.CutPrefix(lower, q.priorityPrefix); ok && s != "" {
+			if p, ok := quickAddPriority(s); ok {
+				task.Priority = p
+				continue
+			}
+		}
+		if s, ok := strings.CutPrefix(word, q.tagPrefix); ok && s != "" {
+			if !slices.Contains(tags, s) {
+				tags = append(tags, s)
+			}
+			continue
+		}
+		if due, ok := quickAddDate(lower, now); ok {
+			task.DueDate = &due
+			continue
+		}
+		words = append(words, word)
+	}
+	task.Text = strings.Join(words, " ")
+	if len(tags) > 0 {
+		if task.Metadata == nil {
+			task.Metadata = map[string]any{}
+		}
+		list := make([]any, len(tags))
+		for i, tag := range tags {
+			list[i] = tag
+		}
+		task.Metadata["tags"] = list
+	}
+}
+
+func quickAddPriority(s string) (int, bool) {
+	if p, ok := quickAddPriorities[s]; ok {
+		return p, true
+	}
+	if len(s) == 1 && s[0] >= '0' && int(s[0]-'0') <= maxPriority {
+		return int(s[0] - '0'), true
+	}
+	return 0, false
+}
+
+// quickAddDate returns the day the word names, at midnight.
+func quickAddDate(word string, now time.Time) (time.Time, bool) {
+	today := startOfDay(now)
+	switch word {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if word == name || word == name[:3] {
+			days := (int(d) - int(now.Weekday()) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			return today.AddDate(0, 0, days), true
+		}
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, word, now.Location()); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseDatePhrase returns the day a phrase of POST /parse-date names, at
+// midnight in the location of now: the words of quickAddDate, "next"
+// followed by a weekday (the same as the weekday alone), "week" or
+// "month", "in N days", "in N weeks" or "in N months", or the short forms
+// "+Nd", "+Nw" and "+Nm".
+func parseDatePhrase(phrase string, now time.Time) (time.Time, bool) {
+	words := strings.Fields(strings.ToLower(phrase))
+	today := startOfDay(now)
+	switch {
+	case len(words) == 1 && strings.HasPrefix(words[0], "+") && len(words[0]) > 2:
+		n, unit := words[0][1:len(words[0])-1], words[0][len(words[0])-1:]
+		units := map[string]string{"d": "days", "w": "weeks", "m": "months"}
+		if units[unit] == "" {
+			return time.Time{}, false
+		}
+		return parseDatePhrase("in "+n+" "+units[unit], now)
+	case len(words) == 1:
+		return quickAddDate(words[0], now)
+	case len(words) == 2 && words[0] == "next":
+		switch words[1] {
+		case "week":
+			return today.AddDate(0, 0, 7), true
+		case "month":
+			return today.AddDate(0, 1, 0), true
+		}
+		if slices.Contains([]string{"today", "tomorrow"}, words[1]) {
+			return time.Time{}, false
+		}
+		return quickAddDate(words[1], now)
+	case len(words) == 3 && words[0] == "in":
+		n, err := strconv.Atoi(words[1])
+		if err != nil || n < 0 || n > 10000 {
+			return time.Time{}, false
+		}
+		switch strings.TrimSuffix(words[2], "s") {
+		case "day":
+			return today.AddDate(0, 0, n), true
+		case "week":
+			return today.AddDate(0, 0, 7*n), true
+		case "month":
+			return today.AddDate(0, n, 0), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// APIQuota is the number of writes made with an API key on a day, in UTC.
+// Keys are stored as SHA-256 hashes.
+type APIQuota struct {
+	bun.BaseModel `bun:"table:APIQuota,alias:q"`
+
+	KeyHash string    `bun:"key_hash,pk"`
+	Day     time.Time `bun:"day,pk,type:date"`
+	Count   int       `bun:"count,notnull"`
+}
+
+// useQuota counts a write made with the key today and returns the number of
+// writes including it. It returns ok false without counting when the key
+// already made limit writes today.
+func useQuota(ctx context.Context, db bun.IDB, key string, limit int, day time.Time) (count int, ok bool, err error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	err = db.NewRaw(`
+		INSERT INTO "APIQuota" AS q (key_hash, day, count) VALUES (?, ?, 1)
+		ON CONFLICT (key_hash, day) DO UPDATE SET count = q.count + 1 WHERE q.count < ?
+		RETURNING count`, hash, day.Format(time.DateOnly), limit).Scan(ctx, &count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return limit, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if count == 1 {
+		// first write of the day: the counters of the past days are done.
+		_, err = db.NewDelete().Model((*APIQuota)(nil)).
+			Where("key_hash = ?", hash).
+			Where("day < ?", day.Format(time.DateOnly)).
+			Exec(ctx)
+	}
+	return count, true, err
+}
+
+// apiQuota returns a middleware allowing each API key limit writes, any
+// method but GET, HEAD and OPTIONS, per day in UTC. The counters are kept
+// in the database so they survive restarts and are shared by instances.
+// Every write gets X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset, the Unix time the quota renews, and writes over the
+// quota are answered with 429 Too Many Requests.
+func apiQuota(db bun.IDB, limit int, public []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if isPublicRoute(c.Path(), public) {
+				return next(c)
+			}
+			now := time.Now().UTC()
+			day := now.Truncate(24 * time.Hour)
+			reset := day.Add(24 * time.Hour)
+			count, ok, err := useQuota(c.Request().Context(), db, requestKey(c), limit, day)
+			if err != nil {
+				return storeError(c, err)
+			}
+			h := c.Response().Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(limit-count))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			if !ok {
+				h.Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+				return c.JSON(http.StatusTooManyRequests, msg(c, "quota_exceeded", limit))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// randomWeights are the weightings of GET /tasks/random, giving the
+// relative chance of a pending task to be picked:
+//
+//   - uniform: every task alike;
+//   - priority: the priority plus one, so a high priority task comes up
+//     four times as often as one without a priority;
+//   - urgency: 4 when overdue, 3 when due by the end of tomorrow, 2 within
+//     a week and 1 otherwise or without a due date;
+//   - mixed: priority times urgency.
+var randomWeights = map[string]func(t *Task, now time.Time) float64{
+	"uniform":  func(*Task, time.Time) float64 { return 1 },
+	"priority": func(t *Task, _ time.Time) float64 { return float64(t.Priority + 1) },
+	"urgency":  urgencyWeight,
+	"mixed": func(t *Task, now time.Time) float64 {
+		return float64(t.Priority+1) * urgencyWeight(t, now)
+	},
+}
+
+func urgencyWeight(t *Task, now time.Time) float64 {
+	switch {
+	case t.DueDate == nil:
+		return 1
+	case t.DueDate.Before(now):
+		return 4
+	case t.DueDate.Before(startOfDay(now).AddDate(0, 0, 2)):
+		return 3
+	case t.DueDate.Before(now.AddDate(0, 0, 7)):
+		return 2
+	}
+	return 1
+}
+
+// pickRandom returns a task of tasks drawn with the chances given by
+// weight, nil when there are none.
+func pickRandom(tasks []Task, weight func(*Task, time.Time) float64, now time.Time) *Task {
+	var total float64
+	weights := make([]float64, len(tasks))
+	for i := range tasks {
+		weights[i] = weight(&tasks[i], now)
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i := range tasks {
+		if r < weights[i] {
+			return &tasks[i]
+		}
+		r -= weights[i]
+	}
+	// float rounding.
+	if len(tasks) > 0 {
+		return &tasks[len(tasks)-1]
+	}
+	return nil
+}
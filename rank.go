@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// rankDigits is the alphabet of rank keys. Keys are compared bytewise, so
+// the database must sort them with the "C" collation.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rankBetween returns a key that sorts strictly between a and b. An empty a
+// means "before everything" and an empty b means "after everything". Keys
+// never end with the zero digit, so there is always room to insert.
+func rankBetween(a, b string) string {
+	if b != "" {
+		n := 0
+		for n < len(b) && rankDigitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + rankBetween(rest, b[n:])
+		}
+	}
+	da := 0
+	if a != "" {
+		da = strings.IndexByte(rankDigits, a[0])
+	}
+	db := len(rankDigits)
+	if b != "" {
+		db = strings.IndexByte(rankDigits, b[0])
+	}
+	if db-da > 1 {
+		return string(rankDigits[(da+db)/2])
+	}
+	if b != "" && len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+	return string(rankDigits[da]) + rankBetween(rest, "")
+}
+
+func rankDigitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return rankDigits[0]
+}
+
+// reorderHandler serves POST /tasks/:id/reorder, which moves the task
+// between the tasks prev and next of the body.
+func reorderHandler(db *bun.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := parseID(c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		// prev and next are the ids of the tasks the moved task should be
+		// placed between. Zero means the start or the end of the list.
+		var req struct {
+			Prev int64 `json:"prev"`
+			Next int64 `json:"next"`
+		}
+		if err := c.Bind(&req); err != nil {
+			c.Logger().Error("Bind: ", err)
+			return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+		}
+		var task Task
+		err = withTx(c.Request().Context(), db, func(ctx context.Context, tx bun.Tx) error {
+			neighbor := func(id int64) (string, error) {
+				if id == 0 {
+					return "", nil
+				}
+				var rank string
+				err := tx.NewSelect().Model((*Task)(nil)).Column("rank").Where("id = ?", id).Scan(ctx, &rank)
+				if errors.Is(err, sql.ErrNoRows) {
+					return "", echo.NewHTTPError(http.StatusNotFound, newError("neighbor_not_found"))
+				}
+				return rank, err
+			}
+			prev, err := neighbor(req.Prev)
+			if err != nil {
+				return err
+			}
+			next, err := neighbor(req.Next)
+			if err != nil {
+				return err
+			}
+			if prev != "" && next != "" && prev >= next {
+				return echo.NewHTTPError(http.StatusBadRequest, newError("prev_after_next"))
+			}
+			result, err := tx.NewUpdate().Model(&task).
+				Set("rank = ?", rankBetween(prev, next)).
+				Set("updated_at = ?", time.Now()).
+				Where("id = ?", id).Returning("*").Exec(ctx)
+			if err != nil {
+				return err
+			}
+			if num, err := result.RowsAffected(); err != nil || num == 0 {
+				return errTaskNotFound
+			}
+			return nil
+		})
+		if err != nil {
+			return storeError(c, err)
+		}
+		return taskResponse(c, http.StatusOK, &task)
+	}
+}
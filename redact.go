@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// redactor removes personal data, such as task texts, from what is
+// logged. A nil redactor leaves everything as is.
+type redactor struct {
+	// params are the query parameters whose values are redacted from
+	// logged URIs.
+	params []string
+	// pattern matches the text redacted from logged URIs, errors and
+	// queries.
+	pattern *regexp.Regexp
+	// hash replaces redacted values by a short hash, so that equal values
+	// can still be correlated, instead of omitting them.
+	hash bool
+}
+
+// sqlStringRe matches the string literals of a formatted query, which hold
+// task texts among others.
+var sqlStringRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// mask returns what s is replaced with.
+func (r *redactor) mask(s string) string {
+	if !r.hash {
+		return "[REDACTED]"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// text returns s with the matches of the pattern redacted.
+func (r *redactor) text(s string) string {
+	if r == nil || r.pattern == nil {
+		return s
+	}
+	return r.pattern.ReplaceAllStringFunc(s, r.mask)
+}
+
+// uri returns the request URI with the values of the redacted parameters
+// masked, and the matches of the pattern in the path and the other values.
+func (r *redactor) uri(s string) string {
+	if r == nil {
+		return s
+	}
+	path, query, ok := strings.Cut(s, "?")
+	path = r.text(path)
+	if !ok {
+		return path
+	}
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		k, v, _ := strings.Cut(pair, "=")
+		key, _ := url.QueryUnescape(k)
+		value, err := url.QueryUnescape(v)
+		switch {
+		case slices.Contains(r.params, key):
+			pairs[i] = k + "=" + r.mask(value)
+		case err == nil && r.pattern != nil && r.pattern.MatchString(value):
+			pairs[i] = k + "=" + r.text(value)
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
+
+// query returns a formatted query with its string literals masked.
+func (r *redactor) query(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.text(sqlStringRe.ReplaceAllStringFunc(s, func(lit string) string {
+		return "'" + r.mask(lit) + "'"
+	}))
+}
+
+// redactingHook passes the queries to hook with redactor.query applied.
+type redactingHook struct {
+	hook     bun.QueryHook
+	redactor *redactor
+}
+
+func (h *redactingHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return h.hook.BeforeQuery(ctx, event)
+}
+
+func (h *redactingHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	redacted := *event
+	redacted.Query = h.redactor.query(event.Query)
+	h.hook.AfterQuery(ctx, &redacted)
+}
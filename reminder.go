@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Reminder records a reminder sent for a task through a channel. A task
+// is reminded once per due date, so moving the due date reminds it again.
+type Reminder struct {
+	bun.BaseModel `bun:"table:Reminder,alias:r"`
+
+	TaskID  int64     `bun:"task_id,pk" json:"task_id"`
+	DueDate time.Time `bun:"due_date,pk" json:"due_date"`
+	Channel string    `bun:"channel,pk" json:"channel"`
+	SentAt  time.Time `bun:"sent_at,nullzero,notnull,default:current_timestamp" json:"sent_at"`
+}
+
+func migrateReminders(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*Reminder)(nil)).
+		IfNotExists().
+		ForeignKey(`("task_id") REFERENCES "Task" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	return err
+}
+
+// Default templates of the reminder emails, executed with the task.
+const (
+	defaultReminderSubject = `Reminder: {{.Text}}`
+	defaultReminderBody    = `{{.Text}}
+
+Due {{.DueDate.Local.Format "2006-01-02 15:04 MST"}}.
+`
+)
+
+// emailReminders emails the pending tasks whose due date is within their
+// ReminderMinutes, or offset when they have none, to a single address.
+// Sent reminders are recorded in the Reminder table before sending, so
+// they are not sent twice across restarts or by several instances, and
+// the record is removed again when sending fails to retry on the next
+// run. Tasks already past due are not reminded.
+type emailReminders struct {
+	db      bun.IDB
+	addr    string // host:port of the SMTP server
+	auth    smtp.Auth
+	from    string
+	to      string
+	offset  time.Duration
+	subject *template.Template
+	body    *template.Template
+}
+
+// reminderChannelEmail is the channel of the reminders sent by email.
+const reminderChannelEmail = "email"
+
+// run sends the reminders every interval until ctx is done.
+func (r *emailReminders) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.send(ctx, time.Now()); err != nil {
+			log.Print("reminders: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send sends the reminders due at now.
+func (r *emailReminders) send(ctx context.Context, now time.Time) error {
+	var tasks []Task
+	err := r.db.NewSelect().Model(&tasks).
+		Where("NOT completed").
+		Where("due_date > ?", now).
+		Where("due_date - make_interval(mins => coalesce(reminder_minutes, ?)) <= ?", int(r.offset.Minutes()), now).
+		Where(`NOT EXISTS (SELECT 1 FROM "Reminder" AS r WHERE r.task_id = t.id AND r.due_date = t.due_date AND r.channel = ?)`, reminderChannelEmail).
+		Order("due_date", "id").
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		reminder := &Reminder{TaskID: task.ID, DueDate: *task.DueDate, Channel: reminderChannelEmail}
+		result, err := r.db.NewInsert().Model(reminder).On("CONFLICT DO NOTHING").Returning("NULL").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if num, err := result.RowsAffected(); err != nil || num == 0 {
+			// sent by another instance meanwhile.
+			continue
+		}
+		if err := r.mail(&task); err != nil {
+			log.Printf("reminders: task %d: %v", task.ID, err)
+			if _, err := r.db.NewDelete().Model(reminder).WherePK().Exec(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mail sends the reminder email of the task.
+func (r *emailReminders) mail(t *Task) error {
+	var subject, body bytes.Buffer
+	if err := r.subject.Execute(&subject, t); err != nil {
+		return err
+	}
+	if err := r.body.Execute(&body, t); err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", k, v)
+	}
+	header("From", r.from)
+	header("To", r.to)
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " ")))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	msg.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&msg)
+	text := strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n")
+	if _, err := w.Write([]byte(text)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return smtp.SendMail(r.addr, r.auth, r.from, []string{r.to}, msg.Bytes())
+}
+
+// smtpAuth returns the PLAIN authentication for the server at addr, or nil
+// when username is empty.
+func smtpAuth(addr, username, password string) smtp.Auth {
+	if username == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return smtp.PlainAuth("", username, password, host)
+}
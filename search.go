@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// highlightStart and highlightStop wrap the words matching ?q= in the
+// highlight of a task, whose text is HTML-escaped.
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// tsHeadline is the ts_headline expression highlighting the search query in
+// the whole text of a task. The words are wrapped in the control
+// characters 1 and 2, removed from the text beforehand, as the text can
+// only be escaped once highlighted, see markHeadline.
+const tsHeadline = `ts_headline('simple', translate(text, chr(1) || chr(2), ''), plainto_tsquery('simple', ?), ` +
+	`'StartSel=' || chr(1) || ', StopSel=' || chr(2) || ', HighlightAll=true')`
+
+// headlineMarks replaces the markers of an escaped tsHeadline.
+var headlineMarks = strings.NewReplacer("\x01", highlightStart, "\x02", highlightStop)
+
+// markHeadline returns the highlight of a tsHeadline HTML-escaped, with its
+// markers replaced by highlightStart and highlightStop.
+func markHeadline(s string) string {
+	return headlineMarks.Replace(html.EscapeString(s))
+}
+
+// isWordBreak reports whether r separates words, roughly like the parser of
+// the simple text search configuration.
+func isWordBreak(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// searchTerms returns the lower-cased words of a search query.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), isWordBreak)
+}
+
+// matchTerms reports whether text contains all the terms as words. It is
+// the memoryStore counterpart of the full text search of the bunStore.
+func matchTerms(text string, terms []string) bool {
+	words := map[string]bool{}
+	for _, w := range searchTerms(text) {
+		words[w] = true
+	}
+	for _, t := range terms {
+		if !words[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// highlightTerms HTML-escapes text and wraps the words that are one of
+// terms like tsHeadline does.
+func highlightTerms(text string, terms []string) string {
+	var b strings.Builder
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, func(r rune) bool { return !isWordBreak(r) })
+		if i < 0 {
+			b.WriteString(html.EscapeString(text))
+			break
+		}
+		b.WriteString(html.EscapeString(text[:i]))
+		text = text[i:]
+		j := strings.IndexFunc(text, isWordBreak)
+		if j < 0 {
+			j = len(text)
+		}
+		word := text[:j]
+		if slices.Contains(terms, strings.ToLower(word)) {
+			b.WriteString(highlightStart + html.EscapeString(word) + highlightStop)
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		text = text[j:]
+	}
+	return b.String()
+}
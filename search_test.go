@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text, q, want string
+	}{
+		{"write report", "report", "write <mark>report</mark>"},
+		{"<script>alert(1)</script> report", "report", "&lt;script&gt;alert(1)&lt;/script&gt; <mark>report</mark>"},
+		{"<script>alert(1)</script>", "script", "&lt;<mark>script</mark>&gt;alert(1)&lt;/<mark>script</mark>&gt;"},
+		{`"a" & 'b'`, "a", `&#34;<mark>a</mark>&#34; &amp; &#39;b&#39;`},
+	}
+	for _, tt := range tests {
+		if got := highlightTerms(tt.text, searchTerms(tt.q)); got != tt.want {
+			t.Errorf("highlightTerms(%q, %q) = %q, want %q", tt.text, tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestMarkHeadline(t *testing.T) {
+	// ts_headline of "<script>alert(1)</script> report" for "report".
+	got := markHeadline("<script>alert(1)</script> \x01report\x02")
+	if want := "&lt;script&gt;alert(1)&lt;/script&gt; <mark>report</mark>"; got != want {
+		t.Errorf("markHeadline() = %q, want %q", got, want)
+	}
+}
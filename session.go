@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sessionConnector is a driver.Connector running statements on every new
+// connection before handing it to the pool, to configure the session. With
+// ping, the connections are pingingConns.
+type sessionConnector struct {
+	driver.Connector
+	statements []string
+	ping       bool
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range c.statements {
+		if _, err := conn.(driver.ExecerContext).ExecContext(ctx, stmt, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	if pc, ok := conn.(pqConn); ok && c.ping {
+		return pingingConn{pc}, nil
+	}
+	return conn, nil
+}
+
+// pqConn are the interfaces of a lib/pq connection that database/sql uses.
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// pingingConn pings the server whenever database/sql takes the connection
+// out of the pool again. A connection that does not answer, such as one to
+// a server that failed over, is discarded and database/sql opens another,
+// instead of handing it to a query that would fail on it.
+type pingingConn struct {
+	pqConn
+}
+
+func (c pingingConn) ResetSession(ctx context.Context) error {
+	if err := c.pqConn.ResetSession(ctx); err != nil {
+		return err
+	}
+	if err := c.Ping(ctx); err != nil {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// sessionTimeouts returns the statements setting the Postgres timeouts of a
+// session that are not zero.
+func sessionTimeouts(timeouts map[string]time.Duration) []string {
+	var statements []string
+	for _, name := range []string{"statement_timeout", "idle_in_transaction_session_timeout"} {
+		if d := timeouts[name]; d > 0 {
+			statements = append(statements, fmt.Sprintf("SET %s = %d", name, d.Milliseconds()))
+		}
+	}
+	return statements
+}
+
+// errNoDatabase is returned by the queries of the features needing Postgres
+// in demo mode.
+var errNoDatabase = errors.New("no database in demo mode")
+
+// noDatabase is the driver.Connector of demo mode, failing every connection
+// with errNoDatabase.
+type noDatabase struct{}
+
+func (noDatabase) Connect(context.Context) (driver.Conn, error) { return nil, errNoDatabase }
+func (noDatabase) Driver() driver.Driver                        { return noDatabase{} }
+func (noDatabase) Open(string) (driver.Conn, error)             { return nil, errNoDatabase }
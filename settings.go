@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Settings is the server configuration the frontend adapts to, served on
+// GET /settings.
+type Settings struct {
+	Version string `json:"version"`
+	// Features tells which optional features the server has, as
+	// configured: demo mode has no dependencies, templates, comments or
+	// activity, and reminders, CalDAV and API keys need their settings.
+	Features map[string]bool `json:"features"`
+	// MaxTasks and MaxTextLength are zero when the number of tasks and
+	// the length of their text are not limited.
+	MaxTasks      int64 `json:"max_tasks"`
+	MaxTextLength int   `json:"max_text_length"`
+	// DefaultPageSize is the number of tasks of GET /tasks without
+	// ?limit=, zero for all of them.
+	DefaultPageSize int `json:"default_page_size"`
+	MaxIDs          int `json:"max_ids"`
+	MaxPriority     int `json:"max_priority"`
+	DefaultPriority int `json:"default_priority"`
+	// PriorityLimits are the maximum numbers of open tasks by priority.
+	PriorityLimits PriorityLimits `json:"priority_limits,omitempty"`
+	DefaultDueIn   string         `json:"default_due_in,omitempty"`
+	UndoWindow     string         `json:"undo_window"`
+	Colors         []string       `json:"colors"`
+	Sorts          []string       `json:"sorts"`
+}
+
+// settingsHandler serves the settings with an ETag so that clients can
+// revalidate them cheaply.
+func settingsHandler(settings Settings) echo.HandlerFunc {
+	b, _ := json.Marshal(settings)
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	return func(c echo.Context) error {
+		setCacheHeaders(c, etag, "no-cache")
+		if c.Request().Header.Get("If-None-Match") == etag {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.JSONBlob(http.StatusOK, b)
+	}
+}
+
+// durationString formats d, or returns "" when it is zero.
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// shareClaims are the contents of a share token of POST /tasks/:id/share.
+type shareClaims struct {
+	TaskID int64 `json:"task_id"`
+	// Version is the ShareVersion of the task when the token was made.
+	// DELETE /tasks/:id/share increments it, revoking the tokens made
+	// before.
+	Version int `json:"v"`
+	// ExpiresAt is the Unix time after which the token is refused, zero
+	// for never.
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// ShareLink is the response of POST /tasks/:id/share.
+type ShareLink struct {
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// shareSigner signs and verifies share tokens with an HMAC-SHA256 key. A
+// token is the claims as base64url JSON, a dot and the signature of that.
+type shareSigner struct {
+	key []byte
+}
+
+func (s *shareSigner) sign(claims shareClaims) string {
+	b, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+func (s *shareSigner) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// verify returns the claims of a token signed with the key and not
+// expired at now.
+func (s *shareSigner) verify(token string, now time.Time) (*shareClaims, bool) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return nil, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, false
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, false
+	}
+	return &claims, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+)
+
+// staleWarning is the Warning header of responses served from a staleCache.
+const staleWarning = `110 - "Response is Stale"`
+
+// maxStaleEntries is the most responses a staleCache keeps.
+const maxStaleEntries = 100
+
+// staleCache keeps the last good response of the list requests, by URI, to
+// serve them while the database is unreachable, see STALE_READS. A nil
+// staleCache keeps nothing.
+type staleCache struct {
+	mu      sync.Mutex
+	entries map[string]any
+	// keys are the keys of entries, oldest first.
+	keys []string
+}
+
+func newStaleCache() *staleCache {
+	return &staleCache{entries: map[string]any{}}
+}
+
+func (s *staleCache) key(c echo.Context) string {
+	return c.Request().URL.RequestURI() + "\x00" + c.Request().Header.Get("X-Envelope")
+}
+
+// put records v as the response of the request.
+func (s *staleCache) put(c echo.Context, v any) {
+	if s == nil {
+		return
+	}
+	key := s.key(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		if len(s.keys) == maxStaleEntries {
+			delete(s.entries, s.keys[0])
+			s.keys = s.keys[1:]
+		}
+		s.keys = append(s.keys, key)
+	}
+	s.entries[key] = v
+}
+
+// serve answers the request with its last good response, with a Warning
+// header, when err means the database is unreachable. It reports whether
+// it did.
+func (s *staleCache) serve(c echo.Context, err error) (bool, error) {
+	if s == nil || !dbUnavailable(err) {
+		return false, nil
+	}
+	s.mu.Lock()
+	v, ok := s.entries[s.key(c)]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	c.Logger().Warn("serving stale response: ", err)
+	c.Response().Header().Set("Warning", staleWarning)
+	return true, c.JSON(http.StatusOK, v)
+}
+
+// dbUnavailable reports whether err means the database could not be
+// reached, rather than a failure of the query.
+func dbUnavailable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, errNoDatabase) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiPrefixes are the paths owned by the API. They never fall back to the
+// frontend so that clients get real 404s.
+var apiPrefixes = []string{"/tasks", "/templates", "/settings", "/preferences", "/tags", "/activity", "/parse-date", "/shared", "/graphql", "/admin"}
+
+func setCacheHeaders(c echo.Context, etag, cacheControl string) {
+	if etag == "" {
+		return
+	}
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", cacheControl)
+}
+
+func isAPIPath(p string) bool {
+	for _, prefix := range apiPrefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// assetETags returns strong ETags computed from the content of every file
+// in fsys, keyed by path.
+func assetETags(fsys fs.FS) map[string]string {
+	etags := map[string]string{}
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		etags[name] = `"` + hex.EncodeToString(sum[:16]) + `"`
+		return nil
+	})
+	return etags
+}
+
+// serveErrorPage answers with the page of fsys and the status code. It
+// returns false when there is no such page.
+func serveErrorPage(c echo.Context, fsys fs.FS, page string, code int) bool {
+	if page == "" {
+		return false
+	}
+	b, err := fs.ReadFile(fsys, page)
+	if err != nil {
+		return false
+	}
+	contentType := mime.TypeByExtension(path.Ext(page))
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+	}
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(code, contentType, b) == nil
+}
+
+// staticErrorHandler returns an echo.HTTPErrorHandler answering server
+// errors on frontend paths with the page of fsys, and API paths as usual.
+func staticErrorHandler(e *echo.Echo, fsys fs.FS, page string) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		code := http.StatusInternalServerError
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			code = he.Code
+		}
+		if code >= 500 && !c.Response().Committed && !isAPIPath(c.Request().URL.Path) {
+			c.Logger().Error(err)
+			if serveErrorPage(c, fsys, page, code) {
+				return
+			}
+		}
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+}
+
+// staticHandler serves the frontend from fsys. Unknown paths without a file
+// extension are answered with index.html so that client side routing works,
+// other missing files with the notFound page when it exists. Responses
+// carry an ETag of the content, and a Cache-Control max-age of maxAge, or
+// no-cache to always revalidate when maxAge is zero.
+func staticHandler(fsys fs.FS, maxAge time.Duration, notFound string) echo.HandlerFunc {
+	fileServer := http.FileServer(http.FS(fsys))
+	etags := assetETags(fsys)
+	cacheControl := "no-cache"
+	if maxAge > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	}
+	return func(c echo.Context) error {
+		p := c.Request().URL.Path
+		if isAPIPath(p) {
+			return echo.ErrNotFound
+		}
+		name := strings.TrimPrefix(path.Clean(p), "/")
+		if name == "" {
+			name = "."
+		}
+		if name == "." {
+			name = "index.html"
+		}
+		if _, err := fs.Stat(fsys, name); err != nil {
+			if path.Ext(name) == "" {
+				setCacheHeaders(c, etags["index.html"], cacheControl)
+				http.ServeFileFS(c.Response(), c.Request(), fsys, "index.html")
+				return nil
+			}
+			if serveErrorPage(c, fsys, notFound, http.StatusNotFound) {
+				return nil
+			}
+		}
+		setCacheHeaders(c, etags[name], cacheControl)
+		fileServer.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
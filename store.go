@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// errTaskNotFound is returned by a TaskStore when there is no task with the
+// requested id.
+var errTaskNotFound = errors.New("task not found")
+
+// errExternalIDTaken is returned by TaskStore.Create when another task has
+// the ExternalID of the new one.
+var errExternalIDTaken = errors.New("external id taken")
+
+// TaskFilter selects the tasks returned by TaskStore.List and Count. Nil
+// and zero fields do not filter.
+type TaskFilter struct {
+	IDs       []int64
+	Completed *bool
+	Starred   *bool
+	Priority  *int
+	// Metadata matches tasks whose metadata contains this object.
+	Metadata map[string]any
+	// Query matches tasks whose text contains all its words. With
+	// Highlight, List fills Task.Highlight.
+	Query     string
+	Highlight bool
+	// StartedBefore matches tasks without a start date or starting before
+	// it.
+	StartedBefore *time.Time
+	// DueFrom and DueBefore match tasks due at or after DueFrom and
+	// before DueBefore.
+	DueFrom   *time.Time
+	DueBefore *time.Time
+	// Waiting matches tasks with, or without, a WaitingFor.
+	Waiting *bool
+	// Include lists the taskRelations List loads into the tasks.
+	Include []string
+	// Sort is a key of taskSorts.
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// sortByRank sorts tasks in the order of the list. Rows returned by UPDATE
+// ... RETURNING come in no particular order and are sorted with it so that
+// responses are stable.
+func sortByRank(tasks []Task) {
+	slices.SortFunc(tasks, func(a, b Task) int { return memorySorts[""](&a, &b) })
+}
+
+// listUnblocked returns the pending tasks matching f that no pending task
+// blocks, for GET /tasks/focus, /tasks/random and /tasks/plan. The
+// blockers are checked whether or not they match f. The whole list is
+// returned, whatever the limit and offset of f.
+func listUnblocked(ctx context.Context, store TaskStore, f TaskFilter) ([]Task, error) {
+	completed := false
+	f.Completed, f.Limit, f.Offset = &completed, 0, 0
+	tasks, err := store.List(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[int64]bool, len(tasks))
+	for _, task := range tasks {
+		pending[task.ID] = true
+	}
+	var others []int64
+	for _, task := range tasks {
+		for _, id := range task.BlockedBy {
+			if !pending[id] && !slices.Contains(others, id) {
+				others = append(others, id)
+			}
+		}
+	}
+	if len(others) > 0 {
+		blockers, err := store.List(ctx, TaskFilter{IDs: others, Completed: &completed})
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range blockers {
+			pending[task.ID] = true
+		}
+	}
+	return slices.DeleteFunc(tasks, func(task Task) bool {
+		return slices.ContainsFunc(task.BlockedBy, func(id int64) bool { return pending[id] })
+	}), nil
+}
+
+// TaskStore is the persistence used by the task handlers.
+type TaskStore interface {
+	// Create appends the task at the end of the list and assigns its id
+	// and rank. It fails with errTaskLimit when MAX_TASKS is reached and
+	// with a *duplicateTaskError when duplicates are rejected and an open
+	// task has the same text.
+	Create(ctx context.Context, task *Task) error
+	List(ctx context.Context, f TaskFilter) ([]Task, error)
+	// Each calls fn with the tasks of List one at a time without holding
+	// them all in memory. BlockedBy and Blocks are not filled.
+	Each(ctx context.Context, f TaskFilter, fn func(*Task) error) error
+	// Count returns the number of tasks matching f ignoring Limit and
+	// Offset.
+	Count(ctx context.Context, f TaskFilter) (int, error)
+	Get(ctx context.Context, id int64) (*Task, error)
+	// Update passes the task to fn and saves it unless fn returns an error,
+	// which is returned as is. Completing a task with incomplete blockers
+	// fails with errTaskBlocked.
+	Update(ctx context.Context, id int64, fn func(*Task) error) (*Task, error)
+	// Delete removes the task and returns it as it was. Deleted tasks are
+	// kept until purged so that they can be restored. at is the time of the
+	// deletion, the same for the tasks deleted together so that Restore
+	// brings them back together.
+	Delete(ctx context.Context, id int64, at time.Time) (*Task, error)
+	// Restore brings back the tasks of the latest deletion made at or after
+	// since. It returns no tasks when there is none.
+	Restore(ctx context.Context, since time.Time) ([]Task, error)
+	// Changes returns the tasks created or updated after since and the ids
+	// of the tasks deleted after since, as long as they are not purged.
+	Changes(ctx context.Context, since time.Time) ([]Task, []int64, error)
+	// Streak returns the completion streaks with days in loc.
+	Streak(ctx context.Context, loc *time.Location) (Streak, error)
+	// Heatmap returns the number of tasks completed per day between from
+	// and to, keyed by their date in loc.
+	Heatmap(ctx context.Context, from, to time.Time, loc *time.Location) (map[string]int, error)
+	// Purge removes the tasks deleted before the time for good.
+	Purge(ctx context.Context, before time.Time) error
+	// DeleteCompleted deletes the tasks completed before the time, like
+	// Delete, and returns how many there were.
+	DeleteCompleted(ctx context.Context, before time.Time) (int, error)
+	// Upsert creates the task, or updates the task with its ExternalID,
+	// restoring it when deleted, and reports whether it was created. An
+	// update replaces the fields of the task but its id, rank and
+	// creation time.
+	Upsert(ctx context.Context, task *Task) (bool, error)
+	// FindDuplicate returns a duplicateTaskError for the open task other
+	// than the task id with the same text, see sameText, or nil when there
+	// is none.
+	FindDuplicate(ctx context.Context, text string, id int64) error
+	// Atomic runs fn with a store whose changes are discarded when fn
+	// returns an error.
+	Atomic(ctx context.Context, fn func(ctx context.Context, store TaskStore) error) error
+}
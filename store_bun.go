@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// taskSorts are the orderings accepted by ?sort= on the list endpoints. The
+// default is the order of the list itself. Each one ends with id so that
+// the order is total and pages neither skip nor repeat tasks with equal
+// keys; memorySorts must do the same.
+var taskSorts = map[string]string{
+	"":        `rank COLLATE "C", id`,
+	"starred": `starred DESC, rank COLLATE "C", id`,
+	// focus puts the most urgent first: highest priority, then earliest
+	// due date, tasks without one last.
+	"focus": `priority DESC, due_date NULLS LAST, rank COLLATE "C", id`,
+}
+
+// bunStore is a TaskStore backed by Postgres.
+type bunStore struct {
+	db       bun.IDB
+	maxTasks int64
+	limits   PriorityLimits
+	// noDuplicates rejects creating a task with the text of an open one.
+	noDuplicates bool
+}
+
+func newBunStore(db *bun.DB, maxTasks int64, limits PriorityLimits, noDuplicates bool) *bunStore {
+	return &bunStore{db: db, maxTasks: maxTasks, limits: limits, noDuplicates: noDuplicates}
+}
+
+// taskCountLock is the key of the advisory lock of lockTaskCounts.
+const taskCountLock = 0x746f646f
+
+// lockTaskCounts takes the transaction-level advisory lock that the checks
+// of MAX_TASKS, PRIORITY_LIMITS and DUPLICATE_TASKS hold until the
+// transaction ends, so that concurrent creates cannot each count the
+// tasks, or look for a duplicate, before the other commits and both
+// pass. Each statement of a READ COMMITTED transaction sees the rows
+// committed before it, so the count taken after the lock includes the
+// writes of the previous holder. db must be a transaction.
+func lockTaskCounts(ctx context.Context, db bun.IDB) error {
+	_, err := db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", taskCountLock)
+	return err
+}
+
+// countTasks counts the tasks, deleted ones aside, for MAX_TASKS under
+// lockTaskCounts.
+func countTasks(ctx context.Context, db bun.IDB) (int, error) {
+	if err := lockTaskCounts(ctx, db); err != nil {
+		return 0, err
+	}
+	return db.NewSelect().Model((*Task)(nil)).Count(ctx)
+}
+
+// countOpen returns a function counting, under lockTaskCounts, the open
+// tasks of the priority other than the task id.
+func countOpen(ctx context.Context, db bun.IDB, priority int, id int64) func() (int, error) {
+	return func() (int, error) {
+		if err := lockTaskCounts(ctx, db); err != nil {
+			return 0, err
+		}
+		return db.NewSelect().Model((*Task)(nil)).
+			Where("NOT completed").
+			Where("priority = ?", priority).
+			Where("id <> ?", id).
+			Count(ctx)
+	}
+}
+
+// taskRelations are the relations of Task accepted by ?include=, with the
+// ordering of the related rows.
+var taskRelations = map[string]struct {
+	name  string
+	order []string
+}{
+	"comments": {name: "Comments", order: []string{"created_at", "id"}},
+}
+
+// loadRelations fills the fields of tasks that are not columns of the Task
+// table.
+func loadRelations(ctx context.Context, db bun.IDB, tasks []Task) error {
+	if err := loadDependencies(ctx, db, tasks); err != nil {
+		return err
+	}
+	return loadCommentCounts(ctx, db, tasks)
+}
+
+// applyTaskFilter adds the WHERE clauses of f to q.
+func applyTaskFilter(q *bun.SelectQuery, f TaskFilter) *bun.SelectQuery {
+	if f.IDs != nil {
+		// the list is sorted and paged as a whole, so the ids cannot be
+		// chunked; they are sent as one array parameter instead of an
+		// IN (...) list of any length.
+		q = q.Where("id = ANY(?)", pgdialect.Array(f.IDs))
+	}
+	if f.Completed != nil {
+		q = q.Where("completed = ?", *f.Completed)
+	}
+	if f.Starred != nil {
+		q = q.Where("starred = ?", *f.Starred)
+	}
+	if f.Priority != nil {
+		q = q.Where("priority = ?", *f.Priority)
+	}
+	if f.Metadata != nil {
+		b, _ := json.Marshal(f.Metadata)
+		q = q.Where("metadata @> ?::jsonb", string(b))
+	}
+	if f.DueFrom != nil {
+		q = q.Where("due_date >= ?", *f.DueFrom)
+	}
+	if f.DueBefore != nil {
+		q = q.Where("due_date < ?", *f.DueBefore)
+	}
+	if f.Waiting != nil {
+		q = q.Where("(waiting_for <> '') = ?", *f.Waiting)
+	}
+	if f.StartedBefore != nil {
+		q = q.Where("start_date IS NULL OR start_date < ?", *f.StartedBefore)
+	}
+	if f.Query != "" {
+		// matches the task_text_idx index.
+		q = q.Where("to_tsvector('simple', text) @@ plainto_tsquery('simple', ?)", f.Query)
+	}
+	return q
+}
+
+// prepareCreate checks that the task can be created and gives it its rank
+// and times.
+func (s *bunStore) prepareCreate(ctx context.Context, tx bun.Tx, task *Task) error {
+	if s.maxTasks > 0 {
+		count, err := countTasks(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if int64(count) >= s.maxTasks {
+			return errTaskLimit
+		}
+	}
+	if !task.Completed {
+		if err := s.limits.check(task.Priority, countOpen(ctx, tx, task.Priority, 0)); err != nil {
+			return err
+		}
+	}
+	if s.noDuplicates {
+		if err := lockTaskCounts(ctx, tx); err != nil {
+			return err
+		}
+		if err := findDuplicate(ctx, tx, task.Text, 0); err != nil {
+			return err
+		}
+	}
+	rank, err := lastRank(ctx, tx)
+	if err != nil {
+		return err
+	}
+	task.Rank = rankBetween(rank, "")
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	task.CompletedAt = nil
+	task.trackCompletion(false, task.CreatedAt)
+	return nil
+}
+
+func (s *bunStore) Create(ctx context.Context, task *Task) error {
+	return withTx(ctx, s.db, func(ctx context.Context, tx bun.Tx) error {
+		if err := s.prepareCreate(ctx, tx, task); err != nil {
+			return err
+		}
+		res, err := tx.NewInsert().Model(task).On("CONFLICT (external_id) DO NOTHING").Exec(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errExternalIDTaken
+		}
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return errExternalIDTaken
+		}
+		return nil
+	})
+}
+
+// upsertColumns are the columns an upsert replaces.
+var upsertColumns = []string{
+	"text", "completed", "completed_at", "due_date", "color", "starred", "priority", "metadata",
+	"updated_at", "estimate_minutes", "reminder_minutes", "start_date", "waiting_for", "checklist",
+}
+
+func (s *bunStore) Upsert(ctx context.Context, task *Task) (bool, error) {
+	var created bool
+	err := withTx(ctx, s.db, func(ctx context.Context, tx bun.Tx) error {
+		var existing Task
+		err := tx.NewSelect().Model(&existing).WhereAllWithDeleted().
+			Where("external_id = ?", task.ExternalID).For("UPDATE").Scan(ctx)
+		created = errors.Is(err, sql.ErrNoRows)
+		switch {
+		case created:
+			if err := s.prepareCreate(ctx, tx, task); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			// a deleted task does not count against the limits, and
+			// restoring it must fit in MAX_TASKS like Restore.
+			deleted := !existing.DeletedAt.IsZero()
+			if deleted && s.maxTasks > 0 {
+				count, err := countTasks(ctx, tx)
+				if err != nil {
+					return err
+				}
+				if int64(count) >= s.maxTasks {
+					return errTaskLimit
+				}
+			}
+			if s.noDuplicates {
+				if err := lockTaskCounts(ctx, tx); err != nil {
+					return err
+				}
+				if err := findDuplicate(ctx, tx, task.Text, existing.ID); err != nil {
+					return err
+				}
+			}
+			wasCompleted := existing.Completed || deleted
+			if entersLimit(task, wasCompleted, existing.Priority) {
+				if err := s.limits.check(task.Priority, countOpen(ctx, tx, task.Priority, existing.ID)); err != nil {
+					return err
+				}
+			}
+			if task.Completed && !existing.Completed {
+				blocked, err := hasIncompleteBlockers(ctx, tx, existing.ID)
+				if err != nil {
+					return err
+				}
+				if blocked {
+					return errTaskBlocked
+				}
+			}
+			task.CompletedAt = existing.CompletedAt
+			task.UpdatedAt = time.Now()
+			task.trackCompletion(existing.Completed, task.UpdatedAt)
+		}
+		// a task created meanwhile with the same ExternalID is updated.
+		q := tx.NewInsert().Model(task).On("CONFLICT (external_id) DO UPDATE").Set("deleted_at = NULL")
+		for _, col := range upsertColumns {
+			q = q.Set("? = EXCLUDED.?", bun.Ident(col), bun.Ident(col))
+		}
+		_, err = q.Returning("*").Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	tasks := []Task{*task}
+	if err := loadRelations(ctx, s.db, tasks); err != nil {
+		return false, err
+	}
+	*task = tasks[0]
+	return created, nil
+}
+
+// listQuery returns the query selecting the tasks of List into model.
+func (s *bunStore) listQuery(model any, f TaskFilter) *bun.SelectQuery {
+	q := applyTaskFilter(s.db.NewSelect().Model(model), f).OrderExpr(taskSorts[f.Sort])
+	if f.Highlight {
+		q = q.ColumnExpr("t.*").ColumnExpr(tsHeadline+" AS highlight", f.Query)
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		q = q.Offset(f.Offset)
+	}
+	return q
+}
+
+func (s *bunStore) List(ctx context.Context, f TaskFilter) ([]Task, error) {
+	tasks := []Task{}
+	q := s.listQuery(&tasks, f)
+	for _, name := range f.Include {
+		rel := taskRelations[name]
+		q = q.Relation(rel.name, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order(rel.order...)
+		})
+	}
+	if err := q.Scan(ctx); err != nil {
+		return nil, err
+	}
+	if f.Highlight {
+		for i := range tasks {
+			tasks[i].Highlight = markHeadline(tasks[i].Highlight)
+		}
+	}
+	return tasks, loadRelations(ctx, s.db, tasks)
+}
+
+func (s *bunStore) Each(ctx context.Context, f TaskFilter, fn func(*Task) error) error {
+	q := s.listQuery((*Task)(nil), f)
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var task Task
+		if err := q.DB().ScanRow(ctx, rows, &task); err != nil {
+			return err
+		}
+		if f.Highlight {
+			task.Highlight = markHeadline(task.Highlight)
+		}
+		if err := fn(&task); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *bunStore) Count(ctx context.Context, f TaskFilter) (int, error) {
+	return applyTaskFilter(s.db.NewSelect().Model((*Task)(nil)), f).Count(ctx)
+}
+
+func (s *bunStore) Get(ctx context.Context, id int64) (*Task, error) {
+	var task Task
+	err := s.db.NewSelect().Model(&task).Where("id = ?", id).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	tasks := []Task{task}
+	if err := loadRelations(ctx, s.db, tasks); err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+func (s *bunStore) Update(ctx context.Context, id int64, fn func(*Task) error) (*Task, error) {
+	var task Task
+	err := withTx(ctx, s.db, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(&task).Where("id = ?", id).For("UPDATE").Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errTaskNotFound
+		}
+		if err != nil {
+			return err
+		}
+		completed, priority := task.Completed, task.Priority
+		if err := fn(&task); err != nil {
+			return err
+		}
+		if entersLimit(&task, completed, priority) {
+			if err := s.limits.check(task.Priority, countOpen(ctx, tx, task.Priority, id)); err != nil {
+				return err
+			}
+		}
+		if task.Completed && !completed {
+			blocked, err := hasIncompleteBlockers(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return errTaskBlocked
+			}
+		}
+		task.UpdatedAt = time.Now()
+		task.trackCompletion(completed, task.UpdatedAt)
+		_, err = tx.NewUpdate().Model(&task).WherePK().Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tasks := []Task{task}
+	if err := loadRelations(ctx, s.db, tasks); err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+func (s *bunStore) Delete(ctx context.Context, id int64, at time.Time) (*Task, error) {
+	var task Task
+	// an UPDATE rather than a soft DELETE, which would stamp its own time.
+	result, err := s.db.NewUpdate().Model(&task).
+		Set("deleted_at = ?", at).
+		Where("id = ?", id).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if num, err := result.RowsAffected(); err != nil || num == 0 {
+		return nil, errTaskNotFound
+	}
+	return &task, nil
+}
+
+func (s *bunStore) Restore(ctx context.Context, since time.Time) ([]Task, error) {
+	tasks := []Task{}
+	err := withTx(ctx, s.db, func(ctx context.Context, tx bun.Tx) error {
+		latest := tx.NewSelect().Model((*Task)(nil)).
+			WhereDeleted().
+			ColumnExpr("max(deleted_at)").
+			Where("deleted_at >= ?", since)
+		_, err := tx.NewUpdate().Model(&tasks).
+			WhereDeleted().
+			Set("deleted_at = NULL").
+			Set("updated_at = ?", time.Now()).
+			Where("deleted_at = (?)", latest).
+			Returning("*").
+			Exec(ctx)
+		if err != nil || s.maxTasks == 0 || len(tasks) == 0 {
+			return err
+		}
+		count, err := countTasks(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if int64(count) > s.maxTasks {
+			return errTaskLimit
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortByRank(tasks)
+	return tasks, loadRelations(ctx, s.db, tasks)
+}
+
+func (s *bunStore) FindDuplicate(ctx context.Context, text string, id int64) error {
+	return findDuplicate(ctx, s.db, text, id)
+}
+
+func (s *bunStore) Atomic(ctx context.Context, fn func(ctx context.Context, store TaskStore) error) error {
+	return withTx(ctx, s.db, func(ctx context.Context, tx bun.Tx) error {
+		return fn(ctx, &bunStore{db: tx, maxTasks: s.maxTasks, limits: s.limits, noDuplicates: s.noDuplicates})
+	})
+}
+
+func (s *bunStore) Changes(ctx context.Context, since time.Time) ([]Task, []int64, error) {
+	tasks := []Task{}
+	err := s.db.NewSelect().Model(&tasks).Where("updated_at > ?", since).Order("updated_at", "id").Scan(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := loadRelations(ctx, s.db, tasks); err != nil {
+		return nil, nil, err
+	}
+	deleted := []int64{}
+	err = s.db.NewSelect().Model((*Task)(nil)).
+		WhereDeleted().
+		Column("id").
+		Where("deleted_at > ?", since).
+		Order("id").
+		Scan(ctx, &deleted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tasks, deleted, nil
+}
+
+func (s *bunStore) Streak(ctx context.Context, loc *time.Location) (Streak, error) {
+	var streak Streak
+	today := dateOf(time.Now(), loc).Format(time.DateOnly)
+	err := s.db.NewRaw(streakSQL, loc.String(), today).Scan(ctx, &streak)
+	return streak, err
+}
+
+func (s *bunStore) Heatmap(ctx context.Context, from, to time.Time, loc *time.Location) (map[string]int, error) {
+	var days []struct {
+		Day   string
+		Count int
+	}
+	err := s.db.NewSelect().Model((*Task)(nil)).
+		ColumnExpr("to_char(date_trunc('day', completed_at AT TIME ZONE ?), 'YYYY-MM-DD') AS day", loc.String()).
+		ColumnExpr("count(*) AS count").
+		Where("completed_at >= ?", from).
+		Where("completed_at < ?", to).
+		GroupExpr("day").
+		Scan(ctx, &days)
+	if err != nil {
+		return nil, err
+	}
+	heatmap := make(map[string]int, len(days))
+	for _, d := range days {
+		heatmap[d.Day] = d.Count
+	}
+	return heatmap, nil
+}
+
+func (s *bunStore) DeleteCompleted(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.db.NewDelete().Model((*Task)(nil)).
+		Where("completed").
+		Where("completed_at < ?", before).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	num, err := result.RowsAffected()
+	return int(num), err
+}
+
+func (s *bunStore) Purge(ctx context.Context, before time.Time) error {
+	_, err := s.db.NewDelete().Model((*Task)(nil)).
+		WhereDeleted().
+		Where("deleted_at < ?", before).
+		ForceDelete().
+		Exec(ctx)
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// testDB returns a database whose tables are in a scratch schema of the
+// TEST_DATABASE_URL database, dropped at the end of the test, and skips
+// the test without TEST_DATABASE_URL.
+func testDB(tb testing.TB) *bun.DB {
+	tb.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx := context.Background()
+	admin := sql.OpenDB(connector)
+	defer admin.Close()
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA todoapp_test`); err != nil {
+		tb.Fatal(err)
+	}
+	sqldb := sql.OpenDB(&sessionConnector{Connector: connector, statements: []string{`SET search_path TO todoapp_test`}})
+	db := bun.NewDB(sqldb, pgdialect.New())
+	tb.Cleanup(func() {
+		db.ExecContext(ctx, `DROP SCHEMA todoapp_test CASCADE`)
+		db.Close()
+	})
+	if err := migrate(ctx, db); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// TestConcurrentCreates creates tasks concurrently against MAX_TASKS and
+// DUPLICATE_TASKS=reject, which must not let more tasks in than the
+// checks done one at a time would.
+func TestConcurrentCreates(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxTasks int64
+		reject   bool
+		want     int
+	}{
+		{name: "max tasks", maxTasks: 5, want: 5},
+		{name: "duplicates", reject: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDB(t)
+			store := newBunStore(db, tt.maxTasks, nil, tt.reject)
+			ctx := context.Background()
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var de *duplicateTaskError
+					err := store.Create(ctx, &Task{Text: "same"})
+					if err != nil && !errors.Is(err, errTaskLimit) && !errors.As(err, &de) {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			count, err := db.NewSelect().Model((*Task)(nil)).Count(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.want {
+				t.Errorf("%d tasks created, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+// benchTasks is the number of tasks of BenchmarkTaskIndexes, one in ten
+// pending and one in a thousand containing "needle".
+const benchTasks = 100000
+
+// BenchmarkTaskIndexes runs the filters of GET /tasks on a large table,
+// with the taskIndexes and with index scans disabled, and logs the plans
+// of both.
+func BenchmarkTaskIndexes(b *testing.B) {
+	db := testDB(b)
+	// the settings of the plans are set on the connection.
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+	exec := func(b *testing.B, query string, args ...any) {
+		b.Helper()
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+	exec(b, `INSERT INTO "Task" (text, completed, rank, due_date)
+		SELECT 'task ' || i || CASE WHEN i % 1000 = 0 THEN ' needle' ELSE '' END,
+			i % 10 <> 0, lpad(i::text, 8, '0'), now() + i * interval '1 minute'
+		FROM generate_series(1, ?) AS i`, benchTasks)
+	exec(b, `ANALYZE "Task"`)
+
+	pending := false
+	from := time.Now()
+	before := from.Add(time.Hour)
+	filters := []struct {
+		name   string
+		filter TaskFilter
+	}{
+		{"pending", TaskFilter{Completed: &pending}},
+		{"due", TaskFilter{DueFrom: &from, DueBefore: &before}},
+		{"search", TaskFilter{Query: "needle"}},
+	}
+	for _, f := range filters {
+		for _, scans := range []string{"on", "off"} {
+			name := f.name + "/indexed"
+			if scans == "off" {
+				name = f.name + "/seqscan"
+			}
+			b.Run(name, func(b *testing.B) {
+				exec(b, `SET enable_indexscan = `+scans)
+				exec(b, `SET enable_bitmapscan = `+scans)
+				defer exec(b, `RESET enable_indexscan`)
+				defer exec(b, `RESET enable_bitmapscan`)
+				var tasks []Task
+				q := applyTaskFilter(db.NewSelect().Model(&tasks), f.filter)
+				var plan string
+				if err := db.QueryRowContext(ctx, "EXPLAIN "+q.String()).Scan(&plan); err != nil {
+					b.Fatal(err)
+				}
+				b.Log(plan)
+				for b.Loop() {
+					tasks = tasks[:0]
+					if err := q.Scan(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memorySorts are the orderings of taskSorts for the memoryStore.
+var memorySorts = map[string]func(a, b *Task) int{
+	"": func(a, b *Task) int {
+		return cmp.Or(cmp.Compare(a.Rank, b.Rank), cmp.Compare(a.ID, b.ID))
+	},
+	"starred": func(a, b *Task) int {
+		if a.Starred != b.Starred {
+			if a.Starred {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.Rank, b.Rank), cmp.Compare(a.ID, b.ID))
+	},
+	"focus": func(a, b *Task) int {
+		if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+			return c
+		}
+		switch {
+		case a.DueDate == nil && b.DueDate != nil:
+			return 1
+		case a.DueDate != nil && b.DueDate == nil:
+			return -1
+		case a.DueDate != nil && b.DueDate != nil:
+			if c := a.DueDate.Compare(*b.DueDate); c != 0 {
+				return c
+			}
+		}
+		return cmp.Or(cmp.Compare(a.Rank, b.Rank), cmp.Compare(a.ID, b.ID))
+	},
+}
+
+// memoryStore is a TaskStore keeping tasks in memory. It is safe for
+// concurrent use.
+type memoryStore struct {
+	mu       sync.Mutex
+	tasks    map[int64]*Task
+	deleted  map[int64]*Task
+	lastID   int64
+	maxTasks int64
+	limits   PriorityLimits
+	// noDuplicates rejects creating a task with the text of an open one.
+	noDuplicates bool
+}
+
+func newMemoryStore(maxTasks int64, limits PriorityLimits, noDuplicates bool) *memoryStore {
+	return &memoryStore{tasks: map[int64]*Task{}, deleted: map[int64]*Task{}, maxTasks: maxTasks, limits: limits, noDuplicates: noDuplicates}
+}
+
+// blocked reports whether any blocker of the task t is still pending.
+func (s *memoryStore) blocked(t *Task) bool {
+	return slices.ContainsFunc(t.BlockedBy, func(id int64) bool {
+		blocker, ok := s.tasks[id]
+		return ok && !blocker.Completed
+	})
+}
+
+// countOpen returns a function counting the open tasks of the priority
+// other than the task id.
+func (s *memoryStore) countOpen(priority int, id int64) func() (int, error) {
+	return func() (int, error) {
+		n := 0
+		for _, t := range s.tasks {
+			if !t.Completed && t.Priority == priority && t.ID != id {
+				n++
+			}
+		}
+		return n, nil
+	}
+}
+
+// copyTask returns a copy of t not sharing the metadata map and the
+// checklist, so callers cannot modify stored tasks.
+func copyTask(t *Task) Task {
+	c := *t
+	c.Metadata = maps.Clone(t.Metadata)
+	c.Checklist = slices.Clone(t.Checklist)
+	return c
+}
+
+// jsonContains reports whether a contains b like the jsonb @> operator.
+func jsonContains(a, b any) bool {
+	switch b := b.(type) {
+	case map[string]any:
+		a, ok := a.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range b {
+			if !jsonContains(a[k], v) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		a, ok := a.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range b {
+			if !slices.ContainsFunc(a, func(x any) bool { return jsonContains(x, v) }) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (f *TaskFilter) match(t *Task) bool {
+	return (f.IDs == nil || slices.Contains(f.IDs, t.ID)) &&
+		(f.Completed == nil || *f.Completed == t.Completed) &&
+		(f.Starred == nil || *f.Starred == t.Starred) &&
+		(f.Priority == nil || *f.Priority == t.Priority) &&
+		(f.Metadata == nil || (t.Metadata != nil && jsonContains(t.Metadata, f.Metadata))) &&
+		(f.DueFrom == nil || (t.DueDate != nil && !t.DueDate.Before(*f.DueFrom))) &&
+		(f.DueBefore == nil || (t.DueDate != nil && t.DueDate.Before(*f.DueBefore))) &&
+		(f.Waiting == nil || *f.Waiting == (t.WaitingFor != "")) &&
+		(f.StartedBefore == nil || t.StartDate == nil || t.StartDate.Before(*f.StartedBefore)) &&
+		(f.Query == "" || matchTerms(t.Text, searchTerms(f.Query)))
+}
+
+func (s *memoryStore) Create(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if task.ExternalID != "" && s.byExternalID(task.ExternalID) != nil {
+		return errExternalIDTaken
+	}
+	return s.create(task)
+}
+
+// byExternalID returns the task, deleted or not, with the external id, or
+// nil.
+func (s *memoryStore) byExternalID(id string) *Task {
+	for _, tasks := range []map[int64]*Task{s.tasks, s.deleted} {
+		for _, t := range tasks {
+			if t.ExternalID == id {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// findDuplicate returns a duplicateTaskError for the open task other than
+// the task id with the same text as text, or nil when there is none, like
+// the findDuplicate of the bunStore. s.mu must be held.
+func (s *memoryStore) findDuplicate(text string, id int64) error {
+	var dup *Task
+	for _, t := range s.tasks {
+		if !t.Completed && t.ID != id && sameText(t.Text, text) && (dup == nil || t.ID < dup.ID) {
+			dup = t
+		}
+	}
+	if dup == nil {
+		return nil
+	}
+	t := copyTask(dup)
+	return &duplicateTaskError{task: &t}
+}
+
+// create checks that the task can be created and stores it. s.mu must be
+// held.
+func (s *memoryStore) create(task *Task) error {
+	if s.maxTasks > 0 && int64(len(s.tasks)) >= s.maxTasks {
+		return errTaskLimit
+	}
+	if !task.Completed {
+		if err := s.limits.check(task.Priority, s.countOpen(task.Priority, 0)); err != nil {
+			return err
+		}
+	}
+	if s.noDuplicates {
+		if err := s.findDuplicate(task.Text, 0); err != nil {
+			return err
+		}
+	}
+	rank := ""
+	for _, t := range s.tasks {
+		rank = max(rank, t.Rank)
+	}
+	s.lastID++
+	task.ID = s.lastID
+	task.Rank = rankBetween(rank, "")
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	task.CompletedAt = nil
+	task.trackCompletion(false, task.CreatedAt)
+	t := copyTask(task)
+	s.tasks[task.ID] = &t
+	return nil
+}
+
+func (s *memoryStore) Upsert(ctx context.Context, task *Task) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing := s.byExternalID(task.ExternalID)
+	if existing == nil {
+		return true, s.create(task)
+	}
+	// a deleted task does not count against the limits, and restoring it
+	// must fit in MAX_TASKS like Restore.
+	_, deleted := s.deleted[existing.ID]
+	if deleted && s.maxTasks > 0 && int64(len(s.tasks)) >= s.maxTasks {
+		return false, errTaskLimit
+	}
+	if s.noDuplicates {
+		if err := s.findDuplicate(task.Text, existing.ID); err != nil {
+			return false, err
+		}
+	}
+	if entersLimit(task, existing.Completed || deleted, existing.Priority) {
+		if err := s.limits.check(task.Priority, s.countOpen(task.Priority, existing.ID)); err != nil {
+			return false, err
+		}
+	}
+	if task.Completed && !existing.Completed && s.blocked(existing) {
+		return false, errTaskBlocked
+	}
+	task.ID = existing.ID
+	task.Rank = existing.Rank
+	task.CreatedAt = existing.CreatedAt
+	task.ShareVersion = existing.ShareVersion
+	task.DeletedAt = time.Time{}
+	task.CompletedAt = existing.CompletedAt
+	task.UpdatedAt = time.Now()
+	task.trackCompletion(existing.Completed, task.UpdatedAt)
+	delete(s.deleted, task.ID)
+	stored := copyTask(task)
+	s.tasks[task.ID] = &stored
+	return false, nil
+}
+
+// filter returns the tasks matching f in the order of f.Sort.
+func (s *memoryStore) filter(f TaskFilter) []Task {
+	tasks := []Task{}
+	for _, t := range s.tasks {
+		if f.match(t) {
+			tasks = append(tasks, copyTask(t))
+		}
+	}
+	sortFunc := memorySorts[f.Sort]
+	slices.SortFunc(tasks, func(a, b Task) int { return sortFunc(&a, &b) })
+	return tasks
+}
+
+func (s *memoryStore) List(ctx context.Context, f TaskFilter) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := s.filter(f)
+	tasks = tasks[min(f.Offset, len(tasks)):]
+	if f.Limit > 0 && f.Limit < len(tasks) {
+		tasks = tasks[:f.Limit]
+	}
+	if f.Highlight {
+		terms := searchTerms(f.Query)
+		for i := range tasks {
+			tasks[i].Highlight = highlightTerms(tasks[i].Text, terms)
+		}
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) Each(ctx context.Context, f TaskFilter, fn func(*Task) error) error {
+	tasks, err := s.List(ctx, f)
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		if err := fn(&tasks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Count(ctx context.Context, f TaskFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.filter(f)), nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id int64) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	task := copyTask(t)
+	return &task, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, id int64, fn func(*Task) error) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	task := copyTask(t)
+	if err := fn(&task); err != nil {
+		return nil, err
+	}
+	if entersLimit(&task, t.Completed, t.Priority) {
+		if err := s.limits.check(task.Priority, s.countOpen(task.Priority, id)); err != nil {
+			return nil, err
+		}
+	}
+	if task.Completed && !t.Completed && s.blocked(t) {
+		return nil, errTaskBlocked
+	}
+	task.ID = id
+	task.UpdatedAt = time.Now()
+	task.trackCompletion(t.Completed, task.UpdatedAt)
+	stored := copyTask(&task)
+	s.tasks[id] = &stored
+	return &task, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id int64, at time.Time) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	delete(s.tasks, id)
+	task := copyTask(t)
+	task.DeletedAt = at
+	deleted := copyTask(&task)
+	s.deleted[id] = &deleted
+	return &task, nil
+}
+
+func (s *memoryStore) Restore(ctx context.Context, since time.Time) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest time.Time
+	for _, t := range s.deleted {
+		if !t.DeletedAt.Before(since) && t.DeletedAt.After(latest) {
+			latest = t.DeletedAt
+		}
+	}
+	tasks := []Task{}
+	if latest.IsZero() {
+		return tasks, nil
+	}
+	for _, t := range s.deleted {
+		if t.DeletedAt.Equal(latest) {
+			tasks = append(tasks, copyTask(t))
+		}
+	}
+	if s.maxTasks > 0 && int64(len(s.tasks)+len(tasks)) > s.maxTasks {
+		return nil, errTaskLimit
+	}
+	for i := range tasks {
+		tasks[i].DeletedAt = time.Time{}
+		tasks[i].UpdatedAt = time.Now()
+		t := copyTask(&tasks[i])
+		s.tasks[t.ID] = &t
+		delete(s.deleted, t.ID)
+	}
+	sortByRank(tasks)
+	return tasks, nil
+}
+
+// FindDuplicate is findDuplicate holding s.mu.
+func (s *memoryStore) FindDuplicate(ctx context.Context, text string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findDuplicate(text, id)
+}
+
+// Atomic runs fn on a copy of the store, which replaces the store when fn
+// succeeds. Other callers wait until fn returns. Stored tasks are never
+// modified in place, so copying the maps is enough.
+func (s *memoryStore) Atomic(ctx context.Context, fn func(ctx context.Context, store TaskStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &memoryStore{
+		tasks:    maps.Clone(s.tasks),
+		deleted:  maps.Clone(s.deleted),
+		lastID:   s.lastID,
+		maxTasks: s.maxTasks,
+		limits:   s.limits,
+
+		noDuplicates: s.noDuplicates,
+	}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	s.tasks, s.deleted, s.lastID = tx.tasks, tx.deleted, tx.lastID
+	return nil
+}
+
+func (s *memoryStore) Changes(ctx context.Context, since time.Time) ([]Task, []int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := []Task{}
+	for _, t := range s.tasks {
+		if t.UpdatedAt.After(since) {
+			tasks = append(tasks, copyTask(t))
+		}
+	}
+	slices.SortFunc(tasks, func(a, b Task) int {
+		return cmp.Or(a.UpdatedAt.Compare(b.UpdatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	deleted := []int64{}
+	for id, t := range s.deleted {
+		if t.DeletedAt.After(since) {
+			deleted = append(deleted, id)
+		}
+	}
+	slices.Sort(deleted)
+	return tasks, deleted, nil
+}
+
+func (s *memoryStore) Streak(ctx context.Context, loc *time.Location) (Streak, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var days []time.Time
+	for _, t := range s.tasks {
+		if t.CompletedAt != nil {
+			days = append(days, dateOf(*t.CompletedAt, loc))
+		}
+	}
+	return computeStreak(days, dateOf(time.Now(), loc)), nil
+}
+
+func (s *memoryStore) Heatmap(ctx context.Context, from, to time.Time, loc *time.Location) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heatmap := map[string]int{}
+	for _, t := range s.tasks {
+		if t.CompletedAt != nil && !t.CompletedAt.Before(from) && t.CompletedAt.Before(to) {
+			heatmap[t.CompletedAt.In(loc).Format(time.DateOnly)]++
+		}
+	}
+	return heatmap, nil
+}
+
+func (s *memoryStore) DeleteCompleted(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for id, t := range s.tasks {
+		if !t.Completed || t.CompletedAt == nil || !t.CompletedAt.Before(before) {
+			continue
+		}
+		delete(s.tasks, id)
+		deleted := copyTask(t)
+		deleted.DeletedAt = now
+		s.deleted[id] = &deleted
+		count++
+	}
+	return count, nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.deleted {
+		if t.DeletedAt.Before(before) {
+			delete(s.deleted, id)
+		}
+	}
+	return nil
+}
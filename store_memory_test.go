@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestSortTies pages through tasks whose sort keys are all equal, which
+// only the id tells apart.
+func TestSortTies(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(0, nil, false)
+	var ids []int64
+	for range 23 {
+		task := &Task{Text: "same", Priority: 2}
+		if err := s.Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	for _, task := range s.tasks {
+		task.Rank = "i"
+	}
+	for sort := range taskSorts {
+		var got []int64
+		for offset := 0; offset < len(ids); offset += 5 {
+			tasks, err := s.List(ctx, TaskFilter{Sort: sort, Limit: 5, Offset: offset})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range tasks {
+				got = append(got, task.ID)
+			}
+		}
+		if !slices.Equal(got, ids) {
+			t.Errorf("sort %q: pages = %v, want %v", sort, got, ids)
+		}
+	}
+}
+
+// TestTaskSorts checks that every ordering of the bunStore ends with the
+// id, so that it is total, and has one of the memoryStore.
+func TestTaskSorts(t *testing.T) {
+	for sort, order := range taskSorts {
+		if !strings.HasSuffix(order, ", id") {
+			t.Errorf("sort %q: %s does not end with the id", sort, order)
+		}
+		if memorySorts[sort] == nil {
+			t.Errorf("sort %q has no memorySorts ordering", sort)
+		}
+	}
+}
+
+func TestCompleteBlocked(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(0, nil, false)
+	blocker := &Task{Text: "blocker"}
+	if err := s.Create(ctx, blocker); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Text: "task", BlockedBy: []int64{blocker.ID}}
+	if err := s.Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	complete := func(task *Task) error {
+		task.Completed = true
+		return nil
+	}
+	if _, err := s.Update(ctx, task.ID, complete); !errors.Is(err, errTaskBlocked) {
+		t.Fatalf("Update of a blocked task: err = %v, want %v", err, errTaskBlocked)
+	}
+	if _, err := s.Update(ctx, blocker.ID, complete); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(ctx, task.ID, complete); err != nil {
+		t.Errorf("Update after the blocker completed: %v", err)
+	}
+}
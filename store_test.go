@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestListUnblocked(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(0, nil, false)
+	work := map[string]any{"tags": []any{"work"}}
+	tasks := []*Task{
+		{Text: "open", Metadata: work},
+		{Text: "blocker elsewhere"},
+		{Text: "done blocker", Completed: true},
+		{Text: "blocked elsewhere", Metadata: work},
+		{Text: "blocked by a done task", Metadata: work},
+		{Text: "blocked here", Metadata: work},
+	}
+	for _, task := range tasks {
+		if err := s.Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.tasks[tasks[3].ID].BlockedBy = []int64{tasks[1].ID}
+	s.tasks[tasks[4].ID].BlockedBy = []int64{tasks[2].ID}
+	s.tasks[tasks[5].ID].BlockedBy = []int64{tasks[0].ID}
+
+	got, err := listUnblocked(ctx, s, TaskFilter{Metadata: map[string]any{"tags": []any{"work"}}, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, task := range got {
+		ids = append(ids, task.ID)
+	}
+	if want := []int64{tasks[0].ID, tasks[4].ID}; !slices.Equal(ids, want) {
+		t.Errorf("listUnblocked() = %v, want %v", ids, want)
+	}
+}
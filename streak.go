@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Streak is the response of GET /tasks/streak. Current counts the
+// consecutive days up to today, or yesterday when nothing was completed
+// today yet, with at least one task completed.
+type Streak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+// streakSQL computes the streaks from the distinct days tasks were
+// completed on, grouping consecutive days by their distance to their row
+// number. The arguments are the time zone and today's date.
+const streakSQL = `
+WITH days AS (
+	SELECT DISTINCT (completed_at AT TIME ZONE ?)::date AS day
+	FROM "Task"
+	WHERE completed_at IS NOT NULL AND deleted_at IS NULL
+), streaks AS (
+	SELECT max(day) AS last, count(*) AS length
+	FROM (SELECT day, day - (row_number() OVER (ORDER BY day))::int AS start FROM days) AS d
+	GROUP BY start
+)
+SELECT
+	coalesce(max(length) FILTER (WHERE last >= ?::date - 1), 0) AS current,
+	coalesce(max(length), 0) AS longest
+FROM streaks`
+
+// computeStreak returns the streak of the completion days, as dates at
+// midnight UTC, like streakSQL does.
+func computeStreak(days []time.Time, today time.Time) Streak {
+	slices.SortFunc(days, time.Time.Compare)
+	days = slices.Compact(days)
+	var s Streak
+	length := 0
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			length++
+		} else {
+			length = 1
+		}
+		s.Longest = max(s.Longest, length)
+	}
+	if n := len(days); n > 0 && !days[n-1].Before(today.AddDate(0, 0, -1)) {
+		s.Current = length
+	}
+	return s
+}
+
+// dateOf returns the date of t in loc as midnight UTC.
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SyncChange is a change made by a client while offline, see POST
+// /tasks/sync.
+type SyncChange struct {
+	// Op is create, update or delete.
+	Op string `json:"op"`
+	// ID is the task updated or deleted.
+	ID int64 `json:"id,omitempty"`
+	// ClientID identifies a created task on the client. It is echoed in
+	// the result so that the client can learn the id of the task.
+	ClientID string `json:"client_id,omitempty"`
+	// BaseUpdatedAt is the updated_at of the task as the client last
+	// received it, before changing it.
+	BaseUpdatedAt *time.Time `json:"base_updated_at,omitempty"`
+	// ChangedAt is when the change was made on the client.
+	ChangedAt time.Time `json:"changed_at"`
+	// Task is the task created.
+	Task json.RawMessage `json:"task,omitempty"`
+	// Update holds the fields changed by an update.
+	Update *TaskUpdate `json:"update,omitempty"`
+}
+
+// Results of the changes of POST /tasks/sync.
+const (
+	// syncApplied is a change applied to a task nobody else changed.
+	syncApplied = "applied"
+	// syncMerged is a change applied over a change made on the server by
+	// another client, because it was made later.
+	syncMerged = "merged"
+	// syncConflict is a change dropped because the task was changed on
+	// the server after it was made.
+	syncConflict = "conflict"
+	// syncError is a change that failed, see the error.
+	syncError = "error"
+)
+
+// SyncResult is the outcome of the SyncChange at Index.
+type SyncResult struct {
+	Index    int    `json:"index"`
+	ClientID string `json:"client_id,omitempty"`
+	Result   string `json:"result"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Task is the task as it is on the server after the change.
+	Task *Task `json:"task,omitempty"`
+}
+
+// SyncResponse is the response of POST /tasks/sync.
+type SyncResponse struct {
+	Results []SyncResult `json:"results"`
+	// Changes are the changes since the since of the request, the ones of
+	// the sync included.
+	Changes Changes `json:"changes"`
+}
+
+// errSyncConflict is returned when a change loses against a later change
+// made on the server.
+var errSyncConflict = errors.New("changed on the server")
+
+// syncStale reports whether a change made at changedAt to a task the client
+// last saw at base loses against the task as it is on the server: the
+// task was changed on the server meanwhile, later than the client changed
+// it.
+func syncStale(task *Task, base *time.Time, changedAt time.Time) (stale, conflict bool) {
+	conflict = base == nil || task.UpdatedAt.After(*base)
+	return conflict && !changedAt.After(task.UpdatedAt), conflict
+}
+
+// applySync applies a change of a client to the store and returns its
+// result and the task as it is afterwards. Conflicts are resolved per task
+// by last write wins, comparing the time of the change with the
+// updated_at of the task. Updates only carry the fields the client
+// changed, so fields changed on the server and not by the client are kept
+// either way.
+func applySync(ctx context.Context, store TaskStore, newTask func() Task, ch *SyncChange) (string, *Task, error) {
+	switch ch.Op {
+	case "create":
+		task := newTask()
+		if err := json.Unmarshal(ch.Task, &task); err != nil {
+			return syncError, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		sanitizeChecklist(task.Checklist)
+		if err := validateTask(&task); err != nil {
+			return syncError, nil, echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		if err := store.Create(ctx, &task); err != nil {
+			return syncError, nil, err
+		}
+		return syncApplied, &task, nil
+
+	case "update":
+		if ch.Update == nil {
+			return syncError, nil, echo.NewHTTPError(http.StatusBadRequest, newError("missing_param", "update"))
+		}
+		var result string
+		task, err := store.Update(ctx, ch.ID, func(task *Task) error {
+			stale, conflict := syncStale(task, ch.BaseUpdatedAt, ch.ChangedAt)
+			if stale {
+				return errSyncConflict
+			}
+			result = syncApplied
+			if conflict {
+				result = syncMerged
+			}
+			ch.Update.apply(task)
+			if err := validateTask(task); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			return nil
+		})
+		if errors.Is(err, errSyncConflict) {
+			task, err = store.Get(ctx, ch.ID)
+			if err != nil {
+				return syncError, nil, err
+			}
+			return syncConflict, task, nil
+		}
+		if err != nil {
+			return syncError, nil, err
+		}
+		return result, task, nil
+
+	case "delete":
+		var result string
+		var current *Task
+		err := store.Atomic(ctx, func(ctx context.Context, store TaskStore) error {
+			result, current = syncApplied, nil
+			task, err := store.Get(ctx, ch.ID)
+			if errors.Is(err, errTaskNotFound) {
+				// already deleted.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			stale, conflict := syncStale(task, ch.BaseUpdatedAt, ch.ChangedAt)
+			if stale {
+				result, current = syncConflict, task
+				return nil
+			}
+			if conflict {
+				result = syncMerged
+			}
+			_, err = store.Delete(ctx, ch.ID, time.Now())
+			return err
+		})
+		if err != nil {
+			return syncError, nil, err
+		}
+		return result, current, nil
+	}
+	return syncError, nil, echo.NewHTTPError(http.StatusBadRequest, newError("invalid_param", "op"))
+}
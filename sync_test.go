@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApplySync(t *testing.T) {
+	priority := 3
+	tests := []struct {
+		name string
+		op   string
+		// base and changed are the base_updated_at and changed_at of the
+		// change relative to the updated_at of the task on the server.
+		base, changed time.Duration
+		want          string
+		// applied reports whether the change reached the task.
+		applied bool
+	}{
+		{name: "update unchanged on the server", op: "update", base: 0, changed: time.Minute, want: syncApplied, applied: true},
+		{name: "update after the server", op: "update", base: -time.Hour, changed: time.Minute, want: syncMerged, applied: true},
+		{name: "update before the server", op: "update", base: -time.Hour, changed: -time.Minute, want: syncConflict},
+		{name: "delete unchanged on the server", op: "delete", base: 0, changed: time.Minute, want: syncApplied, applied: true},
+		{name: "delete after the server", op: "delete", base: -time.Hour, changed: time.Minute, want: syncMerged, applied: true},
+		{name: "delete before the server", op: "delete", base: -time.Hour, changed: -time.Minute, want: syncConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemoryStore(0, nil, false)
+			task := &Task{Text: "sync", Color: "red"}
+			if err := store.Create(ctx, task); err != nil {
+				t.Fatal(err)
+			}
+			base := task.UpdatedAt.Add(tt.base)
+			ch := &SyncChange{
+				Op:            tt.op,
+				ID:            task.ID,
+				BaseUpdatedAt: &base,
+				ChangedAt:     task.UpdatedAt.Add(tt.changed),
+				Update:        &TaskUpdate{Priority: &priority},
+			}
+			result, _, err := applySync(ctx, store, func() Task { return Task{} }, ch)
+			if err != nil || result != tt.want {
+				t.Fatalf("applySync = %q, %v, want %q", result, err, tt.want)
+			}
+			got, err := store.Get(ctx, task.ID)
+			switch {
+			case tt.op == "delete" && tt.applied:
+				if !errors.Is(err, errTaskNotFound) {
+					t.Errorf("Get of the deleted task: err = %v, want %v", err, errTaskNotFound)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case tt.applied != (got.Priority == priority):
+				t.Errorf("priority = %d, applied %v", got.Priority, tt.applied)
+			case got.Color != "red":
+				// fields the client did not change are kept.
+				t.Errorf("color = %q, want red", got.Color)
+			}
+		})
+	}
+}
+
+func TestSyncStaleWithoutBase(t *testing.T) {
+	task := &Task{UpdatedAt: time.Now()}
+	if stale, conflict := syncStale(task, nil, task.UpdatedAt.Add(time.Second)); stale || !conflict {
+		t.Errorf("later change: stale, conflict = %v, %v, want false, true", stale, conflict)
+	}
+	if stale, conflict := syncStale(task, nil, task.UpdatedAt); !stale || !conflict {
+		t.Errorf("change at the same time: stale, conflict = %v, %v, want true, true", stale, conflict)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/uptrace/bun"
+)
+
+// TagCount is a tag and the number of tasks having it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// tagOrders are the orderings of GET /tags by ?sort= name.
+var tagOrders = map[string][]string{
+	"name":  {"name"},
+	"count": {"count DESC", "name"},
+}
+
+// listTags returns the distinct tags of the tasks, the strings of the
+// "tags" arrays of their metadata, with the number of tasks having each.
+func listTags(ctx context.Context, db bun.IDB, order []string) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := db.NewSelect().
+		// the array is checked in the function call, as the condition could
+		// be evaluated after it.
+		TableExpr(`"Task" AS t, jsonb_array_elements_text(CASE WHEN jsonb_typeof(t.metadata->'tags') = 'array' THEN t.metadata->'tags' END) AS tag`).
+		ColumnExpr("tag AS name").
+		ColumnExpr("count(DISTINCT t.id) AS count").
+		Where("t.deleted_at IS NULL").
+		GroupExpr("tag").
+		Order(order...).
+		Scan(ctx, &tags)
+	return tags, err
+}
+
+// assignTag adds the tag to the "tags" array of the metadata of the task
+// and reports whether the task did not have it yet.
+func assignTag(t *Task, tag string) bool {
+	tags := taskTags(t)
+	if slices.Contains(tags, any(tag)) {
+		return false
+	}
+	if t.Metadata == nil {
+		t.Metadata = map[string]any{}
+	}
+	t.Metadata["tags"] = append(slices.Clone(tags), tag)
+	return true
+}
+
+// unassignTag removes the tag from the "tags" array of the metadata of the
+// task and reports whether the task had it.
+func unassignTag(t *Task, tag string) bool {
+	tags := taskTags(t)
+	if !slices.Contains(tags, any(tag)) {
+		return false
+	}
+	t.Metadata["tags"] = slices.DeleteFunc(slices.Clone(tags), func(v any) bool { return v == any(tag) })
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Template is a reusable task. Instantiating it creates a task with its
+// text and priority, due DueIn after the creation time.
+type Template struct {
+	bun.BaseModel `bun:"table:Template,alias:tp"`
+
+	ID       int64  `bun:"id,pk,autoincrement" json:"id"`
+	Text     string `bun:"text,notnull" json:"text"`
+	Priority int    `bun:"priority,notnull,default:0" json:"priority"`
+	// DueIn is a duration such as "72h". Empty falls back to
+	// DEFAULT_DUE_IN.
+	DueIn string `bun:"due_in,notnull,default:''" json:"due_in"`
+}
+
+// validateTemplate checks the fields of a template about to be saved.
+func validateTemplate(t *Template) error {
+	if !validPriority(t.Priority) {
+		return newError("invalid_priority", t.Priority, maxPriority)
+	}
+	if t.DueIn != "" {
+		if d, err := time.ParseDuration(t.DueIn); err != nil || d <= 0 {
+			return newError("invalid_param", "due_in")
+		}
+	}
+	return nil
+}
+
+// newTask returns the task instantiating the template at now.
+func (t *Template) newTask(defaults TaskDefaults, now time.Time) Task {
+	if t.DueIn != "" {
+		defaults.DueIn, _ = time.ParseDuration(t.DueIn)
+	}
+	task := defaults.newTask(now)
+	task.Text = t.Text
+	task.Priority = t.Priority
+	return task
+}
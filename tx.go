@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand/v2"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/uptrace/bun"
+)
+
+// txRetries and txBackoff configure the retries of transactions failing
+// with a transient error, see DB_RETRIES. The n-th retry waits about
+// txBackoff << n.
+var (
+	txRetries = 0
+	txBackoff = 50 * time.Millisecond
+)
+
+// retryable reports whether err is transient, so that running the
+// transaction again may succeed.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01": // deadlock_detected
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// timedOut reports whether err is a query cancelled by a context deadline
+// or by statement_timeout.
+func timedOut(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "57014" { // query_canceled
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// withTx runs fn in a transaction. The transaction is committed when fn
+// returns nil and rolled back when it returns an error or panics. Inside
+// another transaction it uses a savepoint. Otherwise transient failures
+// are retried up to txRetries times, so fn must not keep state between
+// runs.
+func withTx(ctx context.Context, db bun.IDB, fn func(ctx context.Context, tx bun.Tx) error) error {
+	if _, ok := db.(bun.Tx); ok {
+		// an outer transaction that failed cannot be retried from here.
+		return db.RunInTx(ctx, nil, fn)
+	}
+	for attempt := 0; ; attempt++ {
+		err := db.RunInTx(ctx, nil, fn)
+		if err == nil || attempt >= txRetries || !retryable(err) {
+			return err
+		}
+		d := txBackoff << attempt
+		select {
+		case <-time.After(d/2 + rand.N(d/2+1)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// recordingConn is a database/sql driver connection recording the
+// transactions and statements run on it, so that withTx can be tested
+// without a database.
+type recordingConn struct {
+	events []string
+}
+
+func (c *recordingConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *recordingConn) Driver() driver.Driver                        { return c }
+func (c *recordingConn) Open(string) (driver.Conn, error)             { return c, nil }
+func (c *recordingConn) Close() error                                 { return nil }
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	c.events = append(c.events, "begin")
+	return c, nil
+}
+
+func (c *recordingConn) Commit() error {
+	c.events = append(c.events, "commit")
+	return nil
+}
+
+func (c *recordingConn) Rollback() error {
+	c.events = append(c.events, "rollback")
+	return nil
+}
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.events = append(c.events, query)
+	return driver.RowsAffected(1), nil
+}
+
+func newRecordingDB() (*bun.DB, *recordingConn) {
+	conn := &recordingConn{}
+	return bun.NewDB(sql.OpenDB(conn), pgdialect.New()), conn
+}
+
+func TestWithTx(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name   string
+		fn     func(ctx context.Context, tx bun.Tx) error
+		err    error
+		events []string
+	}{
+		{
+			name: "commit",
+			fn: func(ctx context.Context, tx bun.Tx) error {
+				_, err := tx.ExecContext(ctx, "UPDATE 1")
+				return err
+			},
+			events: []string{"begin", "UPDATE 1", "commit"},
+		},
+		{
+			name: "rollback on a failure after a statement",
+			fn: func(ctx context.Context, tx bun.Tx) error {
+				if _, err := tx.ExecContext(ctx, "UPDATE 1"); err != nil {
+					return err
+				}
+				return errFailed
+			},
+			err:    errFailed,
+			events: []string{"begin", "UPDATE 1", "rollback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, conn := newRecordingDB()
+			defer db.Close()
+			err := withTx(context.Background(), db, tt.fn)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("withTx() = %v, want %v", err, tt.err)
+			}
+			if !slices.Equal(conn.events, tt.events) {
+				t.Errorf("events = %q, want %q", conn.events, tt.events)
+			}
+		})
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	db, conn := newRecordingDB()
+	defer db.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("withTx() did not panic")
+		}
+		if want := []string{"begin", "UPDATE 1", "rollback"}; !slices.Equal(conn.events, want) {
+			t.Errorf("events = %q, want %q", conn.events, want)
+		}
+	}()
+	withTx(context.Background(), db, func(ctx context.Context, tx bun.Tx) error {
+		tx.ExecContext(ctx, "UPDATE 1")
+		panic("failed")
+	})
+}
+
+func TestWithTxRetry(t *testing.T) {
+	defer func(retries int) { txRetries = retries }(txRetries)
+	txRetries = 1
+	db, conn := newRecordingDB()
+	defer db.Close()
+	attempts := 0
+	err := withTx(context.Background(), db, func(ctx context.Context, tx bun.Tx) error {
+		attempts++
+		if attempts == 1 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withTx() = %v", err)
+	}
+	if want := []string{"begin", "rollback", "begin", "commit"}; !slices.Equal(conn.events, want) {
+		t.Errorf("events = %q, want %q", conn.events, want)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// colorNames is the palette accepted as a task color besides hex values.
+var colorNames = []string{"red", "orange", "yellow", "green", "blue", "purple", "pink", "gray"}
+
+var hexColorRe = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validColor(s string) bool {
+	return s == "" || hexColorRe.MatchString(s) || slices.Contains(colorNames, s)
+}
+
+// maxPriority is the highest task priority. Priorities range from 0 (none)
+// to maxPriority.
+const maxPriority = 3
+
+func validPriority(n int) bool {
+	return n >= 0 && n <= maxPriority
+}
+
+// maxWaitingForLength is the most characters of Task.WaitingFor.
+const maxWaitingForLength = 100
+
+// maxExternalIDLength is the most characters of Task.ExternalID.
+const maxExternalIDLength = 255
+
+// maxTextLength is the most characters of Task.Text, see MAX_TASK_LENGTH.
+// Zero means no limit.
+var maxTextLength = 0
+
+// maxTags is the most tags a task can have in the "tags" array of its
+// metadata, see MAX_TAGS. Zero means no limit.
+var maxTags = 0
+
+// maxMetadataDepth and maxMetadataBytes bound the nesting of the objects
+// and arrays of the metadata of a task and the size of its JSON, see
+// METADATA_MAX_DEPTH and METADATA_MAX_BYTES. Zero means no limit.
+var (
+	maxMetadataDepth = 0
+	maxMetadataBytes = 0
+)
+
+// jsonDepth returns the nesting depth of a decoded JSON value, 0 for a
+// scalar.
+func jsonDepth(v any) int {
+	depth := 0
+	switch v := v.(type) {
+	case map[string]any:
+		for _, x := range v {
+			depth = max(depth, jsonDepth(x))
+		}
+	case []any:
+		for _, x := range v {
+			depth = max(depth, jsonDepth(x))
+		}
+	default:
+		return 0
+	}
+	return depth + 1
+}
+
+// validateMetadata checks the metadata of a task against maxMetadataDepth
+// and maxMetadataBytes. The metadata object itself is depth 1.
+func validateMetadata(metadata map[string]any) error {
+	if metadata == nil {
+		return nil
+	}
+	if d := jsonDepth(metadata); maxMetadataDepth > 0 && d > maxMetadataDepth {
+		return newError("metadata_too_deep", d, maxMetadataDepth)
+	}
+	if maxMetadataBytes > 0 {
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		if len(b) > maxMetadataBytes {
+			return newError("metadata_too_large", len(b), maxMetadataBytes)
+		}
+	}
+	return nil
+}
+
+// softLimit is a threshold below a hard limit. Reaching it adds a warning
+// to the response, so that users see the limit coming before it rejects
+// their requests.
+type softLimit struct {
+	// soft is the threshold, zero for none.
+	soft int
+	hard int
+}
+
+// parseSoftLimit parses the soft threshold s of the hard limit, which must
+// be set and above it.
+func parseSoftLimit(s string, hard int) (softLimit, error) {
+	soft, err := strconv.Atoi(s)
+	if err != nil || soft < 0 || (soft > 0 && (hard <= 0 || soft >= hard)) {
+		return softLimit{}, errors.New("must be a positive number below the limit")
+	}
+	return softLimit{soft: soft, hard: hard}, nil
+}
+
+// warning returns the warning of the message key for a count of n, with n
+// and the hard limit as arguments, or "" when n is below the threshold.
+func (l softLimit) warning(c echo.Context, key string, n int) string {
+	if l.soft == 0 || n < l.soft {
+		return ""
+	}
+	return msg(c, key, n, l.hard)
+}
+
+// taskSoftLimit and tagSoftLimit warn ahead of MAX_TASKS and MAX_TAGS, see
+// MAX_TASKS_WARN and MAX_TAGS_WARN.
+var taskSoftLimit, tagSoftLimit softLimit
+
+// taskTags returns the "tags" array of the metadata of the task.
+func taskTags(t *Task) []any {
+	tags, _ := t.Metadata["tags"].([]any)
+	return tags
+}
+
+// validateTask checks the fields of a task about to be saved. It returns
+// the first of taskErrors.
+func validateTask(t *Task) error {
+	if errs := taskErrors(t); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// taskErrors returns all the problems of the fields of a task that prevent
+// saving it.
+func taskErrors(t *Task) []error {
+	var errs []error
+	if !validColor(t.Color) {
+		errs = append(errs, newError("invalid_color", t.Color))
+	}
+	if !validPriority(t.Priority) {
+		errs = append(errs, newError("invalid_priority", t.Priority, maxPriority))
+	}
+	if t.EstimateMinutes != nil && *t.EstimateMinutes < 0 {
+		errs = append(errs, newError("invalid_estimate", *t.EstimateMinutes))
+	}
+	if t.ReminderMinutes != nil && *t.ReminderMinutes < 0 {
+		errs = append(errs, newError("invalid_reminder", *t.ReminderMinutes))
+	}
+	if err := validateChecklist(t.Checklist); err != nil {
+		errs = append(errs, err)
+	}
+	if t.StartDate != nil && t.DueDate != nil && t.StartDate.After(*t.DueDate) {
+		errs = append(errs, newError("start_after_due"))
+	}
+	if maxTextLength > 0 && utf8.RuneCountInString(t.Text) > maxTextLength {
+		errs = append(errs, newError("too_long", "text", maxTextLength))
+	}
+	if utf8.RuneCountInString(t.WaitingFor) > maxWaitingForLength {
+		errs = append(errs, newError("too_long", "waiting_for", maxWaitingForLength))
+	}
+	if utf8.RuneCountInString(t.ExternalID) > maxExternalIDLength {
+		errs = append(errs, newError("too_long", "external_id", maxExternalIDLength))
+	}
+	if err := validateMetadata(t.Metadata); err != nil {
+		errs = append(errs, err)
+	}
+	if n := len(taskTags(t)); maxTags > 0 && n > maxTags {
+		errs = append(errs, newError("too_many_tags", n, maxTags))
+	}
+	return errs
+}
+
+// TaskValidation is the response of POST /tasks/validate.
+type TaskValidation struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+// duplicateSimilarity is the share of common words above which a new task
+// is reported as a likely duplicate of an open one.
+const duplicateSimilarity = 0.8
+
+// similarity returns the Jaccard index of the words of a and b.
+func similarity(a, b string) float64 {
+	words := map[string]int{}
+	for _, w := range searchTerms(a) {
+		words[w] |= 1
+	}
+	for _, w := range searchTerms(b) {
+		words[w] |= 2
+	}
+	common := 0
+	for _, v := range words {
+		if v == 3 {
+			common++
+		}
+	}
+	if len(words) == 0 {
+		return 0
+	}
+	return float64(common) / float64(len(words))
+}
+
+// taskWarnings returns the localized warnings about a task about to be
+// created. They do not prevent the creation.
+func taskWarnings(c echo.Context, store TaskStore, t *Task) ([]string, error) {
+	var warnings []string
+	if t.DueDate != nil && t.DueDate.Before(time.Now()) {
+		warnings = append(warnings, msg(c, "warning_past_due"))
+	}
+	if w := tagSoftLimit.warning(c, "warning_tag_limit", len(taskTags(t))); w != "" {
+		warnings = append(warnings, w)
+	}
+	if taskSoftLimit.soft > 0 {
+		n, err := store.Count(c.Request().Context(), TaskFilter{})
+		if err != nil {
+			return nil, err
+		}
+		// counting the task being created.
+		if w := taskSoftLimit.warning(c, "warning_task_limit", n+1); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	if t.Text == "" {
+		return warnings, nil
+	}
+	// open tasks having all the words of the text are the candidates.
+	completed := false
+	tasks, err := store.List(c.Request().Context(), TaskFilter{Completed: &completed, Query: t.Text})
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range tasks {
+		// an upsert is not a duplicate of the task it updates.
+		if t.ExternalID != "" && other.ExternalID == t.ExternalID {
+			continue
+		}
+		if similarity(t.Text, other.Text) >= duplicateSimilarity {
+			warnings = append(warnings, msg(c, "warning_duplicate", formatID(other.ID)))
+			break
+		}
+	}
+	return warnings, nil
+}